@@ -0,0 +1,10 @@
+//go:build !unix
+
+package main
+
+import "time"
+
+// Gives no CPU time: getrusage only exists on the Unix systems (not on Windows, Plan 9 or WebAssembly)
+func processCPUTime() (time.Duration, bool) {
+	return 0, false
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+// Gives the user and system CPU time of the process so far, read with getrusage
+func processCPUTime() (time.Duration, bool) {
+	var usage syscall.Rusage
+	if errUsage := syscall.Getrusage(syscall.RUSAGE_SELF, &usage); errUsage != nil {
+		return 0, false
+	}
+	return time.Duration(usage.Utime.Nano() + usage.Stime.Nano()), true
+}
@@ -1,13 +1,48 @@
 package main
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"container/heap"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
+	"io"
 	"io/fs"
-	"io/ioutil"
+	"log/slog"
+	"maps"
+	"math"
+	"math/rand"
+	"mime"
+	"net/http"
+	"net/url"
 	"os"
+	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"runtime/pprof"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/template"
 	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 /*
@@ -23,21 +58,806 @@ const (
 	dirArg      = "--dir"
 	outArg      = "--out"
 	maxResults  = 10
+
+	// Exit codes of Run
+	exitOk    = 0
+	exitError = 1
+	exitUsage = 2
+	// At least '--fail-count' files are riskier than '--fail-over'
+	exitRisky = 3
+	// A scored file breaks a rule of the '--policy'
+	exitPolicy = 4
+
+	// Files of this size (in bytes) or smaller are not scored, unless told otherwise
+	defaultSizeFloor = 1000
+
+	// Files larger than this (in bytes) are not hashed, unless told otherwise
+	defaultHashMaxSize = 100 * 1000 * 1000
+
+	// Bytes at the start of a file searched for '--exclude-if-contains', unless told otherwise, and at most
+	defaultContainsBytes = 4096
+	maxContainsBytes     = 1000 * 1000
+
+	// Number of files listed in the summary
+	summaryFiles = 5
+
+	// Number of riskiest files listed at the end of a scan watched from a terminal
+	endSummaryFiles = 3
+
+	// Largest config fetched from a URL ('--ext-config', '--weights'), and how long the fetch can take
+	remoteConfigMaxSize = 1 << 20
+	remoteConfigTimeout = 10 * time.Second
+
+	// Largest file uploaded to /assess when serving ('--serve'), and how long reading a request can take
+	maxUploadSize      = 32 << 20
+	serveHeaderTimeout = 10 * time.Second
+	serveReadTimeout   = time.Minute
+
+	// Bytes read from a .properties entry of a Java archive, the secrets are in small files
+	maxPropertiesSize = 1 << 20
+
+	// Directories with more entries than this are crowded, unless told otherwise
+	defaultMaxFilesPerDir = 1000
+
+	// Entries read at once from a directory, so a directory of millions of files is never listed whole in memory
+	dirBatchSize = 1000
+
+	// Delay before the first retry of a failed filesystem operation, doubled for each next one
+	retryDelay = 100 * time.Millisecond
+
+	// File names longer than this (in bytes) are unusual, most file systems stop at 255
+	longNameLength = 128
+
+	// Longest file name (in bytes) written to '--output-dir', the limit of most file systems
+	maxFileNameLength = 255
+
+	// Name of the files listing the patterns to skip in a directory and below
+	ignoreFileName = ".walkscanignore"
+
+	extConfigArg         = "--ext-config"
+	extConfigFormatArg   = "--ext-config-format"
+	mkdirOutArg          = "--mkdir-out"
+	appendArg            = "--append"
+	sensitiveNamesArg    = "--sensitive-names"
+	weightsArg           = "--weights"
+	rescoreArg           = "--rescore"
+	summaryArg           = "--summary"
+	colorArg             = "--color"
+	ignoreFileArg        = "--ignore-file"
+	statsOnlyArg         = "--stats-only"
+	indentArg            = "--indent"
+	sinceArg             = "--since"
+	cpuProfileArg        = "--cpuprofile"
+	memProfileArg        = "--memprofile"
+	streamArg            = "--stream"
+	bandsArg             = "--bands"
+	skipHiddenDirsArg    = "--skip-hidden-dirs"
+	emptyExitCodeArg     = "--empty-exit-code"
+	histogramArg         = "--histogram"
+	histogramBinsArg     = "--histogram-bins"
+	rootsFileArg         = "--roots-file"
+	dedupeByArg          = "--dedupe-by"
+	sizeFloorArg         = "--size-floor"
+	noSizeFloorArg       = "--no-size-floor"
+	onlyMismatchedArg    = "--only-mismatched-type"
+	reportEmptyDirsArg   = "--report-empty-dirs"
+	streamArrayArg       = "--json-stream-array"
+	archivePropagateArg  = "--archive-propagate"
+	partitionRootsArg    = "--partition-roots"
+	failOverArg          = "--fail-over"
+	failCountArg         = "--fail-count"
+	ignoreOwnArg         = "--ignore-own"
+	skipVcsArg           = "--skip-vcs"
+	logFormatArg         = "--log-format"
+	excludeLargerArg     = "--exclude-larger-than"
+	excludeSmallerArg    = "--exclude-smaller-than"
+	manifestArg          = "--manifest"
+	previousManifestArg  = "--previous-manifest"
+	presetArg            = "--preset"
+	retryArg             = "--retry"
+	serveArg             = "--serve"
+	maxReductionArg      = "--max-reduction"
+	followDirSymlinksArg = "--follow-dir-symlinks"
+	maxFilesPerDirArg    = "--max-file-count-per-dir"
+	reportAllArg         = "--report-all"
+	cacheArg             = "--cache"
+	explainFileArg       = "--explain-file"
+	outputDirArg         = "--output-dir"
+	roundArg             = "--round"
+	ignoreEmptyArg       = "--ignore-empty"
+	onlyExtArg           = "--only-ext"
+	compactSummaryArg    = "--compact-summary"
+	policyArg            = "--policy"
+	ignoreCaseArg        = "--ignore-case"
+	regressionsArg       = "--regressions-since"
+	minIncreaseArg       = "--min-increase"
+	sampleArg            = "--sample"
+	seedArg              = "--seed"
+	printConfigArg       = "--print-config"
+	flatArg              = "--flat"
+	deterministicArg     = "--deterministic"
+	gitBlameArg          = "--git-blame"
+	outputTemplateArg    = "--output-template"
+	top1Arg              = "--top1"
+	noClampArg           = "--no-clamp"
+	specialFilesArg      = "--include-special-files"
+	quietArg             = "--quiet"
+	hashArg              = "--hash"
+	hashMaxSizeArg       = "--hash-max-size"
+	topPerDirArg         = "--top-per-dir"
+	topGlobalArg         = "--top-global"
+	ignoreSizeMinArg     = "--ignore-size-min"
+	ignoreSizeMaxArg     = "--ignore-size-max"
+	onErrorArg           = "--on-error"
+	relativeToArg        = "--relative-to"
+	excludeArg           = "--exclude"
+	timeFormatArg        = "--time-format"
+
+	excludeIfContainsArg      = "--exclude-if-contains"
+	excludeIfContainsBytesArg = "--exclude-if-contains-bytes"
+	mergeRootsArg             = "--merge-roots-output"
+	outputEncodingArg         = "--output-encoding"
 )
 
-// A file path and its associated risk
+// Arguments followed by a value
+var valueArgs = []string{
+	dirArg, outArg, extConfigArg, extConfigFormatArg, sensitiveNamesArg, weightsArg, rescoreArg, colorArg, ignoreFileArg,
+	indentArg, sinceArg, cpuProfileArg, memProfileArg, bandsArg, emptyExitCodeArg,
+	histogramArg, histogramBinsArg, rootsFileArg, dedupeByArg, sizeFloorArg,
+	hashArg, hashMaxSizeArg, topPerDirArg, topGlobalArg, ignoreSizeMinArg, ignoreSizeMaxArg,
+	onErrorArg, relativeToArg, excludeArg, timeFormatArg, failOverArg, failCountArg,
+	logFormatArg, excludeLargerArg, excludeSmallerArg, manifestArg,
+	outputTemplateArg, previousManifestArg, presetArg, retryArg,
+	serveArg, maxReductionArg, maxFilesPerDirArg, cacheArg,
+	explainFileArg, outputDirArg, roundArg, onlyExtArg,
+	policyArg, regressionsArg, minIncreaseArg, sampleArg, seedArg,
+	excludeIfContainsArg, excludeIfContainsBytesArg, mergeRootsArg, outputEncodingArg,
+}
+
+// Arguments without a value, only their presence matters
+var switchArgs = []string{
+	mkdirOutArg, appendArg, summaryArg, statsOnlyArg, streamArg, skipHiddenDirsArg,
+	noSizeFloorArg, onlyMismatchedArg, reportEmptyDirsArg, streamArrayArg, archivePropagateArg,
+	partitionRootsArg, ignoreOwnArg, skipVcsArg, top1Arg,
+	noClampArg, specialFilesArg, quietArg, followDirSymlinksArg,
+	reportAllArg, ignoreEmptyArg, compactSummaryArg, ignoreCaseArg,
+	printConfigArg, flatArg, deterministicArg, gitBlameArg,
+}
+
+// A file path and its associated risk.
+// Size and ModTime are kept so that a report can be scored again without the files (see rescoreReport).
 type FileResult struct {
-	Path string
-	Risk float64
+	Path    string
+	Risk    float64
+	Size    int64
+	ModTime time.Time
+	Label   string   `json:",omitempty"`
+	Notes   []string `json:",omitempty"`
+	// Content hash prefixed by its algorithm ("sha256:..."), when asked for
+	Hash string `json:",omitempty"`
+	// The risky entries of an archive ('--archive-propagate')
+	Archive *ArchiveResult `json:",omitempty"`
+	// The last commit of the file ('--git-blame'), when it is tracked by git
+	Git *GitCommit `json:",omitempty"`
+	// How Path is encoded when it isn't valid UTF-8 ("escape" or "base64", see '--output-encoding'), only set in the output
+	PathEncoding string `json:",omitempty"`
+}
+
+// The last commit changing a file
+type GitCommit struct {
+	Commit string
+	Author string
+	Date   time.Time
+}
+
+// The entries of an archive scored on their names, so a risky entry can be traced back to its archive
+type ArchiveResult struct {
+	// zip, tar or tgz
+	Format string
+	// Only the entries riskier than minRisk, in the order of the archive
+	Entries []ArchiveEntry
+}
+
+// An entry of an archive, its path in the archive and its risk
+type ArchiveEntry struct {
+	Path  string
+	Risk  float64
+	Notes []string `json:",omitempty"`
+	// How Path is encoded when it isn't valid UTF-8, like the one of FileResult
+	PathEncoding string `json:",omitempty"`
 }
 
 // A directory, potentially containing files with risks
 type DirResult struct {
-	Dir     string
+	Dir string `json:",omitempty"`
+	// Every scanned directory, when there is more than one
+	Roots   []string `json:",omitempty"`
 	Results []FileResult
+	// How Dir and each of the Roots are encoded when they aren't valid UTF-8, like the Path of FileResult. The keys of
+	// RootResults are encoded like Roots. Only set in the output, RootEncodings only when a root is encoded.
+	DirEncoding   string   `json:",omitempty"`
+	RootEncodings []string `json:",omitempty"`
+	// The results of each scanned directory, keyed by its absolute path, when asked for ('--partition-roots').
+	// Results then only holds the results out of every root.
+	RootResults map[string][]FileResult `json:",omitempty"`
+	Summary     *Summary                `json:",omitempty"`
+	// Problems met during the scan, the paths involved may be missing from the results
+	Warnings []Warning `json:",omitempty"`
+	// The scored files breaking a rule of the '--policy', even the ones left out of the results
+	Violations []Violation `json:",omitempty"`
+}
+
+// A scored file breaking a rule of the policy
+type Violation struct {
+	Rule string
+	Path string
+	Risk float64
+	// How Path is encoded when it isn't valid UTF-8, like the one of FileResult
+	PathEncoding string `json:",omitempty"`
+}
+
+// A path that couldn't be scanned properly and why
+type Warning struct {
+	Path    string
+	Message string
+	// How Path is encoded when it isn't valid UTF-8, like the one of FileResult
+	PathEncoding string `json:",omitempty"`
+}
+
+// Aggregated metrics over every scored file, not only the ones kept in the results
+type Summary struct {
+	ScoredFiles int
+	AverageRisk float64
+	MaxRisk     float64
+	Bands       map[string]int
+	Extensions  map[string]*ExtensionStats
+	// Number of files in each of the equal-width risk bins between minRisk and maxRisk, when asked for
+	Histogram []int `json:",omitempty"`
+	// Number of files by age of their last modification: day, week, month, year or older
+	Ages map[string]int
+	// Where a scan spent its time, missing for '--rescore'
+	Timing *Timing `json:",omitempty"`
+	// Percentage of the files drawn to be scored, when they are sampled ('--sample'): the results are an estimate
+	SamplePercent float64 `json:",omitempty"`
+
+	totalRisk float64
+	// The time the ages are computed from
+	now time.Time
+	// Files riskier than failOver are counted in riskyFiles, when it isn't negative ('--fail-over')
+	failOver   float64
+	riskyFiles int
+	// Files met by the walk, scored or filtered out
+	seenFiles int
+}
+
+// Wall-clock durations of the phases of a scan, in seconds, and the CPU time of the whole scan where the system gives
+// it (getrusage, see processCPUTime in cpuTime_unix.go and cpuTime_other.go)
+type Timing struct {
+	// From the start of the program to the writing of the report, which can't include its own writing
+	Total float64
+	// Listing the directories and reading the file information, without the two next phases
+	Walk float64
+	// Applying the rules to the files, including the content reads ('--only-mismatched-type', '--archive-propagate')
+	Scoring float64
+	// Writing the results while walking ('--stream', '--json-stream-array')
+	Output float64
+	// User and system CPU time of the process over the same span as Total, missing on the systems without getrusage
+	CPU float64 `json:",omitempty"`
+}
+
+// Metrics of the scored files sharing an extension
+type ExtensionStats struct {
+	Files       int
+	AverageRisk float64
+	MaxRisk     float64
+
+	totalRisk float64
+}
+
+// Settings of a scan, read from the command line. The callers of AssessPaths start from DefaultOptions(): in the zero
+// value, the size limits are set to 0 and leave out every file that isn't empty.
+type Options struct {
+	// Only files modified after this time are scored, when set
+	Since time.Time
+	// Don't descend into directories whose name starts with a dot (.git, .cache...)
+	SkipHiddenDirs bool
+	// Don't descend into the directories of version control systems (.git, .hg, .svn), full of large objects
+	SkipVcs bool
+	// Files of this size or smaller are not scored
+	SizeFloor int64
+	// Score every file whatever its size, small files can be the most sensitive ones (.env...)
+	NoSizeFloor bool
+	// Algorithm used to hash the reported files (md5 or sha256), none when empty
+	Hash string
+	// Files larger than this are not hashed
+	HashMaxSize int64
+	// Number of riskiest files kept for each directory
+	TopPerDir int
+	// Number of riskiest files kept overall, after the per directory limit. The larger of 10 and TopPerDir when 0.
+	TopGlobal int
+	// Files with a size in this range (bounds included) are not scored. A negative bound is not set, leaving that side open.
+	IgnoreSizeMin int64
+	IgnoreSizeMax int64
+	// Files larger or smaller than these are never scored nor read, even broken symlinks. Negative when not set.
+	ExcludeLargerThan  int64
+	ExcludeSmallerThan int64
+	// What to do with a file that can't be stat'ed during the walk: skip (only logged), warn (keep it in the warnings) or fail
+	OnError string
+	// The reported paths are relative to this absolute directory when set, the paths out of it stay absolute
+	RelativeTo string
+	// Only score the files whose content doesn't match their extension
+	OnlyMismatchedType bool
+	// Report the directories without any scored file (in them or below) as zero risk results
+	ReportEmptyDirs bool
+	// Raise the risk of an archive (zip, tar) to the one of its riskiest entry, scored on its name
+	ArchivePropagate bool
+	// Don't score the files owned by the user running the scan. Only on systems with file owners (not Windows).
+	IgnoreOwn bool
+	// Score the devices, named pipes and sockets too, not only the regular files and the symlinks
+	IncludeSpecialFiles bool
+	// Number of times a directory listing or a file information is tried again when it fails for a reason that may not
+	// last (flaky network mounts)
+	Retries int
+	// Walk the directories that symlinks point to, the symlinks to files are still scored as they are
+	FollowDirSymlinks bool
+	// Directories with more entries than this are crowded, see the CrowdedDir weight
+	MaxFilesPerDir int
+	// Never score the empty regular files, whatever the size floor
+	IgnoreEmpty bool
+	// When set, only the files with one of these extensions (lower case, with the dot) are scored
+	OnlyExtensions []string
+	// When set, only this percentage of the files passing the filters are scored, drawn at random with Seed
+	SamplePercent float64
+	Seed          int64
+	// When set, the files matching this in their first ExcludeIfContainsBytes bytes are not scored (generated files...)
+	ExcludeIfContains      *regexp.Regexp
+	ExcludeIfContainsBytes int64
+	// Add the last commit of the reported files tracked by git, a better sign of recent activity than their times
+	GitBlame bool
+}
+
+// The settings of a walk and what is collected while walking the tree, beside the results
+type scanState struct {
+	options Options
+	summary *Summary
+
+	// When set, the results of each directory are written here as soon as it is done, instead of being returned
+	stream *json.Encoder
+	// When set ('--json-stream-array'), the results of each directory are written here as soon as it is done, instead of being returned
+	array *arrayWriter
+	// When set ('--top-global'), the results of each directory are added here instead of being returned
+	global *ResultCollector
+
+	warnings []Warning
+	// Set when the walk is aborted ('--on-error fail'), nothing more is walked once it is set
+	err error
+	// Where the errors met during the walk are logged
+	logger *slog.Logger
+
+	// When set ('--manifest'), every path met during the walk is written here with what was done with it
+	manifest *json.Encoder
+	// When set ('--previous-manifest'), the scored files are compared with what they were in the previous scan
+	previous *previousManifest
+
+	// Time spent scoring the files, and writing the streamed results
+	scoring time.Duration
+	output  time.Duration
+
+	// Real paths of the directories already walked through a symlink ('--follow-dir-symlinks')
+	followed map[string]bool
+
+	// When set ('--sample'), draws the files to score
+	sample *rand.Rand
+
+	// When set ('--regressions-since'), only the files riskier than in this baseline are reported
+	baseline *baseline
+
+	// When set ('--policy'), the scored files are checked against it and the ones breaking it are kept in violations
+	policy     *Policy
+	violations []Violation
+
+	// When set ('--output-dir'), the results of each directory are written to their own file in it, instead of being returned
+	outputDir string
+
+	// When set ('--cache'), the risks of the rules on the files themselves in the previous scan by path, and where the
+	// ones of this scan are written
+	cache       map[string]CacheEntry
+	cacheWriter *json.Encoder
+}
+
+// Runs a filesystem operation, trying it again up to '--retry' times with a growing delay while it fails for a reason that
+// may not last. A missing file or a permission denied won't change, they aren't tried again.
+func (state *scanState) retry(operation func() error) error {
+	err := operation()
+	delay := retryDelay
+	for attempt := 0; attempt < state.options.Retries && err != nil; attempt++ {
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission) {
+			break
+		}
+		time.Sleep(delay)
+		delay *= 2
+		err = operation()
+	}
+	return err
+}
+
+// Tells if a symlink found in dir points to a directory to walk ('--follow-dir-symlinks'). When the directory would
+// make the walk loop (one of the parents of dir, or a directory already walked through another symlink), the reason
+// to skip it is given instead. Symlinks to files and broken symlinks are neither.
+func (state *scanState) followDirLink(link string, dir string) (bool, string) {
+	target, errTarget := filepath.EvalSymlinks(link)
+	if errTarget != nil {
+		return false, ""
+	}
+	targetInfo, errStat := os.Stat(target)
+	if errStat != nil || !targetInfo.IsDir() {
+		return false, ""
+	}
+	realDir, errDir := filepath.EvalSymlinks(dir)
+	if errDir != nil {
+		return false, ""
+	}
+	if strings.HasPrefix(realDir+string(os.PathSeparator), target+string(os.PathSeparator)) {
+		return false, "symlink to a parent directory"
+	}
+	if state.followed[target] {
+		return false, "directory already walked through another symlink"
+	}
+	state.followed[target] = true
+	return true, ""
+}
+
+// Gives the risks of the rules on a file itself from the cache, when its size and modification time are the same as in
+// the previous scan
+func (state *scanState) cachedInputs(path string, info fs.FileInfo) (fileInputs, bool) {
+	entry, known := state.cache[path]
+	if !known || entry.Size != info.Size() || !entry.ModTime.Equal(info.ModTime()) {
+		return fileInputs{}, false
+	}
+	inputs := fileInputs{notes: entry.Notes, archive: entry.Archive}
+	for _, step := range entry.Steps {
+		inputs.risk.add(step.Rule, step.Risk)
+	}
+	return inputs, true
+}
+
+// Writes the risks of the rules on a scored file itself to the cache for the next scan, when there is one
+func (state *scanState) cacheInputs(path string, info fs.FileInfo, inputs fileInputs) {
+	if state.cacheWriter == nil {
+		return
+	}
+	entry := CacheEntry{Path: path, Size: info.Size(), ModTime: info.ModTime(), Notes: inputs.notes, Archive: inputs.archive}
+	for _, step := range inputs.risk.steps {
+		entry.Steps = append(entry.Steps, CacheStep{Rule: step.rule, Risk: step.risk})
+	}
+	if errEncode := state.cacheWriter.Encode(entry); errEncode != nil {
+		state.warn(path, "writing the cache", errEncode)
+	}
+}
+
+// Runs the scoring of a file, which gives its result or why it is skipped, turning a panic of a rule into an error
+// so one malformed file can't crash the whole scan and lose the results of the others
+func safeScore(score func() (FileResult, string)) (result FileResult, skipped string, err error) {
+	defer func() {
+		if recovered := recover(); recovered != nil {
+			err = fmt.Errorf("scoring panicked: %v", recovered)
+		}
+	}()
+	result, skipped = score()
+	return result, skipped, nil
+}
+
+// Logs an error met during the walk and keeps it for the report
+func (state *scanState) warn(path string, message string, err error) {
+	state.logger.Warn("error occured while "+message, "path", path, "error", err)
+	state.warnings = append(state.warnings, Warning{Path: path, Message: err.Error()})
+}
+
+// Writes what was done with a path to the manifest, when there is one. Writing stops at the first error.
+// The information of the path is missing when it couldn't be read.
+func (state *scanState) record(path string, info fs.FileInfo, decision string, reason string) {
+	if state.manifest == nil {
+		return
+	}
+	entry := ManifestEntry{Path: path, Decision: decision, Reason: reason}
+	if info != nil {
+		entry.Size = info.Size()
+		entry.Inode, _ = fileInode(info)
+	}
+	if errEncode := state.manifest.Encode(entry); errEncode != nil {
+		state.manifest = nil
+		state.warn(path, "writing the manifest", errEncode)
+	}
+}
+
+// A line of the manifest: a path met during the walk and what was done with it
+type ManifestEntry struct {
+	Path string
+	// scored, skipped (by a filter), ignored (by an ignore pattern) or error
+	Decision string
+	Reason   string `json:",omitempty"`
+	// Size and inode number of the path, when they are known. Windows has no inode.
+	Size  int64  `json:",omitempty"`
+	Inode uint64 `json:",omitempty"`
+	// How Path is encoded when it isn't valid UTF-8, like the one of FileResult
+	PathEncoding string `json:",omitempty"`
+}
+
+// A line of the cache ('--cache'): a scored file and the risks of the rules on the file itself, which don't change while
+// the file doesn't. The rules on its times and surroundings (RecentChange, CrowdedDir...) are applied again on each
+// scan. Unlike FileResult, the time format is always RFC 3339.
+type CacheEntry struct {
+	Path    string
+	Size    int64
+	ModTime time.Time
+	Steps   []CacheStep    `json:",omitempty"`
+	Notes   []string       `json:",omitempty"`
+	Archive *ArchiveResult `json:",omitempty"`
+}
+
+// The risk one rule added to a cached file
+type CacheStep struct {
+	Rule string
+	Risk float64
+}
+
+// The first line of the cache, before its entries: the format of the entries and the fingerprint of the configuration
+// they were scored with
+type cacheHeader struct {
+	Version     int
+	Fingerprint string
+}
+
+// Bumped when the entries of the cache change, so the caches written by older versions are ignored
+const cacheVersion = 2
+
+// Constraints on the scored files ('--policy'), read from a json file
+type Policy struct {
+	Rules []PolicyRule
+}
+
+// A constraint on the scored files under a directory (Under) and with one of some extensions, every file when they are
+// empty: they can't be riskier than MaxRisk, or can't be there at all when Forbidden
+type PolicyRule struct {
+	Name       string
+	Under      string   `json:",omitempty"`
+	Extensions []string `json:",omitempty"`
+	MaxRisk    *float64 `json:",omitempty"`
+	Forbidden  bool     `json:",omitempty"`
+}
+
+// The configuration a scan runs with, once the preset, the config files and the arguments are applied ('--print-config')
+type effectiveConfig struct {
+	Roots          []string
+	Options        Options
+	Weights        Weights
+	Zones          map[string]float64
+	Bands          []Band
+	Extensions     ExtensionConfig
+	SensitiveNames []string
+	TimeFormat     string
+	ClampRisks     bool
+	// Negative when the reductions aren't capped, all the decimals are written when RoundDigits is negative
+	MaxReduction float64
+	RoundDigits  int
+	IgnoreCase   bool
+	// The config files read, by argument, the secrets of their URLs redacted
+	ConfigFiles map[string][]string
+}
+
+// Hashes what the risks of the files depend on in the configuration, so a cache scored with another one is ignored.
+// The roots, the time format, the rounding and the config file locations don't change a cached risk, nor do the time
+// filter and the seed of the sample, which only choose the scored files. The rules applied again on each scan aren't
+// cached, their weights can change.
+func (config effectiveConfig) fingerprint() string {
+	config.Roots, config.TimeFormat, config.RoundDigits, config.ConfigFiles = nil, "", -1, nil
+	config.Options.Since, config.Options.Seed, config.Options.MaxFilesPerDir = time.Time{}, 0, 0
+	config.Weights.RecentChange, config.Weights.RecentCreation, config.Weights.CrowdedDir = 0, 0, 0
+	config.Weights.ManifestChange, config.Weights.Untracked = 0, 0
+	encoded, _ := json.Marshal(config)
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(encoded))
+}
+
+// A manifest of a previous scan ('--previous-manifest'), to spot the files that changed since
+type previousManifest struct {
+	entries map[string]ManifestEntry
+	// Inode → path it had in the previous scan
+	paths map[uint64]string
+}
+
+// How much risk each rule adds. Extension is a factor applied to the values of extensionConfig.
+type Weights struct {
+	LargeFile     float64
+	RecentChange  float64
+	SensitiveName float64
+	Extension     float64
+	ShortDirName  float64
+	MediumDirName float64
+	LongDirName   float64
+	BrokenLink    float64
+	// Off by default, set it to add risk to very long names or names with control characters
+	UnusualName float64
+	// Off by default, set it to add risk to names mixing scripts, such as a Cyrillic 'а' among Latin letters
+	ConfusableName float64
+	// Off by default, set it to add risk to files created in the last week, where the system keeps the creation time
+	RecentCreation float64
+	// Off by default, set it to add risk to executable files without an extension (binaries and scripts on POSIX)
+	ExtensionlessExecutable float64
+	// Added to the files whose inode was another file's, or whose size changed a lot, since the '--previous-manifest'
+	ManifestChange float64
+	// Off by default, set it to add risk to files in a directory holding more than '--max-file-count-per-dir' entries
+	CrowdedDir float64
+	// Off by default, set it to add risk to files in a git repository that git doesn't track (local secrets, dumps)
+	Untracked float64
+}
+
+var weights = defaultWeights()
+
+// A named range of risks, from Min (included) to the Min of the next band
+type Band struct {
+	Label string
+	Min   float64
+}
+
+// The risk bands, sorted by Min
+var bands = defaultBands()
+
+// Counters over every run of the process, exposed at /metrics when serving ('--serve')
+type runMetrics struct {
+	lock          sync.Mutex
+	scans         int
+	scannedFiles  int
+	scoredFiles   int
+	highRiskFiles int
+	errors        int
+	// Sum of the durations of the scans, in seconds
+	duration float64
+}
+
+var metrics runMetrics
+
+// The files git doesn't track, looked up once per repository for the Untracked weight
+type gitStatusCache struct {
+	lock sync.Mutex
+	// Directory → top of its repository, "" when out of any
+	repos map[string]string
+	// Top of a repository → its untracked files (ignored ones included)
+	untracked map[string]map[string]bool
+}
+
+var gitStatus = newGitStatusCache()
+
+// Counts a finished scan: its files, the ones in the riskiest band, its warnings and its duration
+func (m *runMetrics) addScan(report DirResult, duration time.Duration) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.scans++
+	m.scannedFiles += report.Summary.seenFiles
+	m.scoredFiles += report.Summary.ScoredFiles
+	m.highRiskFiles += report.Summary.Bands[bands[len(bands)-1].Label]
+	m.errors += len(report.Warnings)
+	m.duration += duration.Seconds()
+}
+
+// Counts a scan that couldn't finish
+func (m *runMetrics) addError() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.errors++
+}
+
+// Writes the counters in the Prometheus text format. It is written by hand: the client library (client_golang) would
+// be the first dependency of the program, which builds from its single file without a module. Moving to it needs a
+// go.mod first, with the same metric names and types (see "What is missing" in the README).
+func (m *runMetrics) write(writer io.Writer) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	counters := []struct {
+		name  string
+		help  string
+		value int
+	}{
+		{"walkscan_scanned_files_total", "Files met by the scans, scored or not.", m.scannedFiles},
+		{"walkscan_scored_files_total", "Files scored by the scans.", m.scoredFiles},
+		{"walkscan_high_risk_files_total", "Scored files in the riskiest band.", m.highRiskFiles},
+		{"walkscan_errors_total", "Warnings of the scans and scans that failed.", m.errors},
+	}
+	for _, counter := range counters {
+		fmt.Fprintf(writer, "# HELP %v %v\n# TYPE %v counter\n%v %v\n", counter.name, counter.help, counter.name, counter.name, counter.value)
+	}
+	fmt.Fprintf(writer, "# HELP walkscan_scan_duration_seconds Duration of the scans.\n# TYPE walkscan_scan_duration_seconds summary\n")
+	fmt.Fprintf(writer, "walkscan_scan_duration_seconds_sum %v\nwalkscan_scan_duration_seconds_count %v\n", m.duration, m.scans)
+}
+
+// Whether the risks are kept between minRisk and maxRisk. Without it ('--no-clamp') the risks are the plain sum of the rules.
+var clampRisks = true
+
+// How much risk the negative rules (images, long directory names...) can remove from a file at most, unlimited when negative ('--max-reduction')
+var maxReduction = -1.0
+
+// How ModTime is written in the report: rfc3339, unix (epoch seconds) or a Go layout ("2006-01-02 15:04")
+var timeFormat = "rfc3339"
+
+// Number of decimals of the risks written in the json output, all of them when negative ('--round')
+var roundDigits = -1
+
+// How the paths that aren't valid UTF-8 are written in the json output ('--output-encoding'): replace (the invalid
+// bytes become U+FFFD, as encoding/json does), escape (\xNN, and \\ for a backslash) or base64 (the whole path)
+var pathEncoding = "replace"
+
+// A glob pattern from an ignore file. Patterns containing a separator are matched against the path relative to base,
+// the directory of the ignore file, others against the file name only.
+type ignorePattern struct {
+	base    string
+	pattern string
+	// A pattern starting with '!' includes again what the previous patterns ignored
+	negate bool
+}
+
+// The risk of the extensions, either set for a single extension or shared by all the extensions of a category
+type ExtensionConfig struct {
+	// Extension → risk
+	Risks map[string]float64
+	// Category → risk
+	Categories map[string]float64
+	// Extension → category
+	Extensions map[string]string
+	// Extensions always scored maxRisk, whatever the other rules say (.pem, .key...)
+	AlwaysMax []string
+}
+
+var extensionConfig = initExtensionConfig()
+
+// Directory → factor of the risk of the files under it, from the Zones section of the weights file
+var zones map[string]float64
+
+// The rules read from the config files ('--weights', '--ext-config'), which a served scan reloads on SIGHUP
+type configFiles struct {
+	weights         Weights
+	zones           map[string]float64
+	extensionConfig ExtensionConfig
+}
+
+// The config files of the served scans, which use it instead of reading the files, nil when not serving
+var servedConfig atomic.Pointer[configFiles]
+
+// Whether the ignore patterns and the extensions match whatever the case ('--ignore-case', or a case-insensitive file system)
+var ignoreCase = false
+
+var sensitiveFileNames = initSensitiveFileNames()
+
+// The directories where version control systems keep their data ('--skip-vcs')
+var vcsDirNames = makeNameSet([]string{".git", ".hg", ".svn"})
+
+// The first bytes of the content types that can be recognized, checked in order
+var magicNumbers = []struct {
+	contentType string
+	magic       []byte
+}{
+	{"zip", []byte("PK\x03\x04")},
+	{"gzip", []byte{0x1f, 0x8b}},
+	{"7z", []byte("7z\xbc\xaf\x27\x1c")},
+	{"rar", []byte("Rar!\x1a\x07")},
+	{"pdf", []byte("%PDF-")},
+	{"png", []byte("\x89PNG\r\n\x1a\n")},
+	{"jpeg", []byte{0xff, 0xd8, 0xff}},
+	{"gif", []byte("GIF8")},
+	{"elf", []byte("\x7fELF")},
+	{"exe", []byte("MZ")},
+	{"script", []byte("#!")},
 }
 
-var extensionRiskMap map[string]float64
+// The archives built by Java, whose configuration entries can hold credentials
+var javaArchiveExtensions = []string{".jar", ".war", ".ear"}
+
+// The content type expected for each extension, the other extensions are never a mismatch
+var extensionContentTypes = map[string]string{
+	".zip": "zip", ".jar": "zip", ".war": "zip", ".docx": "zip", ".xlsx": "zip", ".pptx": "zip",
+	".gz": "gzip", ".tgz": "gzip", ".7z": "7z", ".rar": "rar", ".pdf": "pdf",
+	".png": "png", ".jpg": "jpeg", ".jpeg": "jpeg", ".gif": "gif",
+	".exe": "exe", ".dll": "exe", ".so": "elf",
+	".sh": "script", ".txt": "text", ".csv": "text", ".json": "text", ".md": "text", ".log": "text",
+}
 
 /*
 ===============
@@ -45,24 +865,47 @@ Part two: Risk assessment rules
 ===============
 */
 
+// Gives the extension of a file, in lower case when the case is ignored
+func fileExtension(path string) string {
+	if ignoreCase {
+		return strings.ToLower(filepath.Ext(path))
+	}
+	return filepath.Ext(path)
+}
+
 // Checks how much risk to apply based on the file extension
 func assessExtension(path string) float64 {
 
 	// Extract the extension
-	extension := filepath.Ext(path)
+	extension := fileExtension(path)
 
-	risk, ok := extensionRiskMap[extension]
-
-	if ok {
-		return risk
+	// A risk set for the extension itself wins over the one of its category
+	risk, ok := extensionConfig.Risks[extension]
+	if !ok {
+		category, categorized := extensionConfig.Extensions[extension]
+		if !categorized {
+			// Extension was not recognized: ignoring it
+			return 0
+		}
+		risk = extensionConfig.Categories[category]
 	}
 
-	// Extension was not recognized: ignoring it
+	return risk * weights.Extension
+}
+
+// Checks if the file name is exactly one of the known sensitive file names (id_rsa, .env...)
+func assessFileName(path string) float64 {
+	if sensitiveFileNames[filepath.Base(path)] {
+		return weights.SensitiveName
+	}
 	return 0
 }
 
-// Makes sure the risk is within the defined bounds (0.0 - 1.0)
+// Makes sure the risk is within the defined bounds (0.0 - 1.0), unless clampRisks is off
 func checkRiskRange(risk float64) float64 {
+	if !clampRisks {
+		return risk
+	}
 	if risk > maxRisk {
 		return maxRisk
 	}
@@ -72,23 +915,60 @@ func checkRiskRange(risk float64) float64 {
 	return risk
 }
 
-// Calculates the risk of a given file as a float between 0.0 (low risk) to 1.0 (high risk)
-func assessFileRisk(path string, info fs.FileInfo) float64 {
-	var risk float64 = 0.0
+// Sum of the risks added by the rules, keeping the reductions (negative risks) apart so they can be capped.
+// The rules that changed the risk are kept in order, to explain it ('--explain-file').
+type riskSum struct {
+	added   float64
+	removed float64
+	steps   []riskStep
+}
+
+// The risk one rule added to a file
+type riskStep struct {
+	rule string
+	risk float64
+}
+
+func (sum *riskSum) add(rule string, risk float64) {
+	if risk == 0 {
+		return
+	}
+	sum.steps = append(sum.steps, riskStep{rule, risk})
+	if risk < 0 {
+		sum.removed -= risk
+	} else {
+		sum.added += risk
+	}
+}
+
+// Gives the sum of the risks, where the reductions can't remove more than maxReduction when it isn't negative
+func (sum riskSum) total() float64 {
+	removed := sum.removed
+	if maxReduction >= 0 && removed > maxReduction {
+		removed = maxReduction
+	}
+	return sum.added - removed
+}
 
-	// If the file size is larger than 1mb → Add 0.25
+// Adds up the risks of the rules on the file itself (size, extension, name, times), before they are bounded to 0.0 - 1.0
+func assessFileRisk(path string, info fs.FileInfo) riskSum {
+	var risk riskSum
+
+	// If the file size is larger than 1mb → Add 0.25 (LargeFile weight)
 	if info.Size() > 1000000 {
-		risk += 0.25
+		risk.add("LargeFile", weights.LargeFile)
 	}
 
-	risk += assessExtension(path)
+	risk.add("Extension", assessExtension(path))
 
-	// If the file was modified in the last week → Add 0.20
-	timeLastWeek := time.Now().Add(time.Hour * -hoursInWeek)
-	if info.ModTime().After(timeLastWeek) {
-		risk += 0.20
+	// Binaries and scripts usually have no extension on POSIX, so the extension rule misses them → Add ExtensionlessExecutable weight, 0 by default
+	if filepath.Ext(path) == "" && info.Mode().IsRegular() && info.Mode().Perm()&0111 != 0 {
+		risk.add("ExtensionlessExecutable", weights.ExtensionlessExecutable)
 	}
 
+	// If the file name is a known sensitive one → Add 0.75 (SensitiveName weight)
+	risk.add("SensitiveName", assessFileName(path))
+
 	return risk
 }
 
@@ -96,205 +976,4111 @@ func assessFileRisk(path string, info fs.FileInfo) float64 {
 func assessDirNameLength(path string) float64 {
 	size := len(path)
 	if size < 5 {
-		return 0.25
+		return weights.ShortDirName
 	}
 	if size > 15 {
-		return -0.10
+		return weights.LongDirName
 	}
-	return 0.5
+	return weights.MediumDirName
 }
 
-// Assess the risk of a directory
-func assessDirRisk(path string) []FileResult {
-
-	var finalResult, currentDirResults []FileResult
-
-	dirs, errReadDir := ioutil.ReadDir(path)
-	if errReadDir == nil {
-		for _, dir := range dirs {
+// Checks if a file is a symlink whose target doesn't exist
+func isBrokenLink(path string, info fs.FileInfo) bool {
+	if info.Mode()&fs.ModeSymlink == 0 {
+		return false
+	}
+	_, errStat := os.Stat(path)
+	return errors.Is(errStat, fs.ErrNotExist)
+}
 
-			absName := path + string(os.PathSeparator) + dir.Name()
+// Tells what is unusual about a file name: its length, or control and invisible characters (null bytes, right-to-left override...)
+func unusualName(name string) string {
+	if len(name) > longNameLength {
+		return "very long name"
+	}
+	if !utf8.ValidString(name) {
+		return "name isn't valid UTF-8"
+	}
+	for _, char := range name {
+		if !unicode.IsPrint(char) {
+			return "name with control or invisible characters"
+		}
+	}
+	return ""
+}
 
-			fileInfo, errLstat := os.Lstat(absName)
+// The scripts whose letters look alike, so a name mixing them is likely disguised
+var confusableScripts = []struct {
+	name  string
+	table *unicode.RangeTable
+}{
+	{"Latin", unicode.Latin},
+	{"Cyrillic", unicode.Cyrillic},
+	{"Greek", unicode.Greek},
+}
 
-			if errLstat == nil {
-				if fileInfo.IsDir() {
-					dirResults := assessDirRisk(absName)
-					for _, res := range dirResults {
-						finalResult = append(finalResult, res)
-					}
-				} else {
-					// If the file size is lower than 1 KB ignore it.
-					if fileInfo.Size() > 1000 {
-						// fmt.Printf("Assessing: %v\n", path)
-						var fileResult FileResult
-						fileResult.Path = absName
-						fullRisk := assessFileRisk(path, fileInfo) + assessDirNameLength(path)
-						fileResult.Risk = checkRiskRange(fullRisk)
-						currentDirResults = append(currentDirResults, fileResult)
-					}
-				}
-			} else {
-				fmt.Printf("Error occured while getting file info: %v\n", errLstat)
+// Tells which scripts a file name mixes, when its letters come from more than one of confusableScripts
+func confusableName(name string) string {
+	var scripts []string
+	for _, char := range name {
+		for _, script := range confusableScripts {
+			if unicode.Is(script.table, char) && !slices.Contains(scripts, script.name) {
+				scripts = append(scripts, script.name)
 			}
 		}
-	} else {
-		fmt.Printf("Error occured while list dirs: %v\n", errReadDir)
 	}
-
-	// Trim down to 10 files for this dir, keeping the subdirs intact
-	for _, res := range trimDownResults(currentDirResults) {
-		finalResult = append(finalResult, res)
+	if len(scripts) < 2 {
+		return ""
 	}
-
-	return finalResult
+	return "name mixes " + strings.Join(scripts, " and ") + " letters"
 }
 
-/*
-===============
-Part three: Util functions
-===============
-*/
-
-// Initializes a map with risk values for all the extensions we check. This should only be run once at the start of the application.
-// TODO This could potentially be externalized to a json/csv file and read as config.
-func initExtensionRiskMap() map[string]float64 {
-	extensionValues := make(map[string]float64)
-
-	// If the file has the extension [zip, tar] → Add 0.15
-	extensionValues[".zip"] = 0.15
-	extensionValues[".tar"] = 0.15
+// Scores a file with all the rules, keeping the information needed to score it again later
+func scoreFile(path string, info fs.FileInfo) FileResult {
+	fileResult, _ := scoreFileSteps(path, info, fileContext{})
+	return fileResult
+}
 
-	// If the file is an image [png, jpeg] → Remove 0.20
-	extensionValues[".png"] = -0.20
-	extensionValues[".jpg"] = -0.20
-	extensionValues[".jpeg"] = -0.20
+// What the walk knows of a file beside its information, for the rules on its surroundings. The zero value is a file
+// scored on its own (AssessFile, '--rescore'), to which these rules don't apply.
+type fileContext struct {
+	// The file is only known from a previous report ('--rescore'), by its path, size and modification time: the rules
+	// looking at the disk or running git don't apply, the file may not even be there anymore
+	reported bool
+	// Number of entries of the directory of the file, which is crowded beyond maxDirEntries (CrowdedDir)
+	dirEntries    int
+	maxDirEntries int
+	// The files of the previous scan ('--previous-manifest'), the file may have been replaced since (ManifestChange)
+	previous *previousManifest
+}
 
-	// If the extension is [csv, json] → Add 0.75
-	extensionValues[".csv"] = 0.75
-	extensionValues[".json"] = 0.75
+// The risks of the rules on a file itself, its name, size and mode, which don't change while the file doesn't. They are
+// what the cache keeps ('--cache').
+type fileInputs struct {
+	risk  riskSum
+	notes []string
+	// The risky entries of an archive, when '--archive-propagate' is set
+	archive *ArchiveResult
+}
 
-	return extensionValues
+// Scores a file like scoreFile, in its context, and also gives the risk of each rule before the bounds are applied
+func scoreFileSteps(path string, info fs.FileInfo, context fileContext) (FileResult, riskSum) {
+	return scoreInContext(path, info, context, scoreFileInputs(path, info))
 }
 
-// Reads the command line arguments.
-// We need values for '--dir' and '--out'. Doesn't matter the order, ignore other args.
-// There is probably a better way of doing this in a library somewhere but I don't know enough Go to know about it...
-func readCommandLineArgs() map[string]string {
-	args := os.Args[1:]
-	size := len(args)
+// Runs the rules on a file itself
+func scoreFileInputs(path string, info fs.FileInfo) fileInputs {
+	inputs := fileInputs{risk: assessFileRisk(path, info)}
+	inputs.risk.add("DirName", assessDirNameLength(filepath.Dir(path)))
 
-	var result map[string]string = make(map[string]string)
+	// A symlink to nothing can point to a deleted sensitive file → Add 0.10 (BrokenLink weight)
+	if isBrokenLink(path, info) {
+		inputs.risk.add("BrokenLink", weights.BrokenLink)
+		inputs.notes = append(inputs.notes, "broken symlink")
+	}
 
-	for i := 0; i < size; i++ {
-		// Get the root directory
-		if dirArg == args[i] && i+1 < size {
-			result[dirArg] = args[i+1]
-			// Skip a step because we consumed the value already
-			i++
-		}
+	// A very long name or invisible characters can hide what a file is → Add UnusualName weight, 0 by default
+	if note := unusualName(filepath.Base(path)); note != "" && weights.UnusualName != 0 {
+		inputs.risk.add("UnusualName", weights.UnusualName)
+		inputs.notes = append(inputs.notes, note)
+	}
 
-		// Get the output file name
-		if outArg == args[i] && i+1 < size {
-			result[outArg] = args[i+1]
-			// Skip a step because we consumed the value already
-			i++
-		}
+	// Letters of another script can disguise a sensitive name (a Cyrillic 'а' in "pаsswords.txt") → Add ConfusableName weight, 0 by default
+	if note := confusableName(filepath.Base(path)); note != "" && weights.ConfusableName != 0 {
+		inputs.risk.add("ConfusableName", weights.ConfusableName)
+		inputs.notes = append(inputs.notes, note)
 	}
 
-	return result
+	return inputs
 }
 
-// Finds the smallest risk and its index in an array of FileResult
-func findSmallestRisk(results [maxResults]FileResult) (float64, int) {
-	smallestRisk := maxRisk
-	smallestRiskIndex := 0
+// Scores a file from the risks of the rules on the file itself, adding the rules on its times and its surroundings,
+// which change from one scan to the next even when the file doesn't
+func scoreInContext(path string, info fs.FileInfo, context fileContext, inputs fileInputs) (FileResult, riskSum) {
+	var fileResult FileResult
+	fileResult.Path = path
+	fileResult.Size = info.Size()
+	fileResult.ModTime = info.ModTime()
+	fileResult.Notes = slices.Clone(inputs.notes)
 
-	for i, r := range results {
-		if r.Risk < smallestRisk {
-			smallestRisk = r.Risk
-			smallestRiskIndex = i
-		}
+	fullRisk := inputs.risk
+	fullRisk.steps = slices.Clone(fullRisk.steps)
 
+	// If the file was modified in the last week → Add 0.20 (RecentChange weight)
+	timeLastWeek := time.Now().Add(time.Hour * -hoursInWeek)
+	if info.ModTime().After(timeLastWeek) {
+		fullRisk.add("RecentChange", weights.RecentChange)
 	}
 
-	return smallestRisk, smallestRiskIndex
-}
-
-// Reduce the number of results to the maximum allowed
-func trimDownResults(results []FileResult) []FileResult {
-
-	if len(results) <= maxResults {
-		return results
+	// A file created in the last week can be a dropped payload → Add RecentCreation weight, 0 by default
+	if created, ok := fileBirthTime(info); ok && created.After(timeLastWeek) {
+		fullRisk.add("RecentCreation", weights.RecentCreation)
 	}
 
-	trimmedResults := [maxResults]FileResult{}
+	// Thousands of files in one directory are often a dump or a cache → Add CrowdedDir weight, 0 by default
+	if weights.CrowdedDir != 0 && context.dirEntries > context.maxDirEntries {
+		fullRisk.add("CrowdedDir", weights.CrowdedDir)
+		fileResult.Notes = append(fileResult.Notes, fmt.Sprintf("directory holds %v entries", context.dirEntries))
+	}
 
-	// TODO this is definitely not the most efficient way to do this
-	for i, result := range results {
-		if i < maxResults {
-			trimmedResults[i] = result
-		} else {
-			smallestRisk, smallestRiskIndex := findSmallestRisk(trimmedResults)
-			if smallestRisk < result.Risk {
-				// Replace the lowest risk with then new one
-				trimmedResults[smallestRiskIndex] = result
-			}
+	// A file replaced or rewritten since the previous scan → Add ManifestChange weight
+	if context.previous != nil {
+		if notes := context.previous.changes(path, info); len(notes) > 0 {
+			fullRisk.add("ManifestChange", weights.ManifestChange)
+			fileResult.Notes = append(fileResult.Notes, notes...)
 		}
 	}
 
-	return trimmedResults[:]
-}
-
-// Write the DirResultStructure to the output file
-func writeJsonToFile(outFile *os.File, data DirResult) {
-	encoder := json.NewEncoder(outFile)
-	encoder.SetIndent("", "    ")
-	// fmt.Printf("Object before writing: %v\n", data)
-	encoder.Encode(data)
-}
+	// Files left out of git in a repository are local ones: secrets, dumps → Add Untracked weight, 0 by default
+	if weights.Untracked != 0 && !context.reported && gitStatus.isUntracked(path) {
+		fullRisk.add("Untracked", weights.Untracked)
+		fileResult.Notes = append(fileResult.Notes, "not tracked by git")
+	}
 
-/*
-===============
- Part four: Main
-===============
-*/
+	risk := fullRisk.total()
 
-func main() {
+	// The same file is riskier in a public directory than in a scratch one → Multiply by the factor of its zone
+	if zone, factor, inZone := pathZone(path); inZone {
+		risk *= factor
+		fileResult.Notes = append(fileResult.Notes, fmt.Sprintf("in zone %v (x%v)", zone, factor))
+	}
 
-	// Init
-	extensionRiskMap = initExtensionRiskMap()
+	fileResult.Risk = checkRiskRange(risk)
 
-	// Command line arguments without the program name
-	args := readCommandLineArgs()
+	// Strict policies don't want keys and certificates to ever score low
+	if slices.Contains(extensionConfig.AlwaysMax, fileExtension(path)) {
+		fileResult.Risk = maxRisk
+		fileResult.Notes = append(fileResult.Notes, "always max extension")
+	}
+	fileResult.Label = riskBand(fileResult.Risk)
 
-	rootDir, dirExists := args[dirArg]
-	outFileName, outExists := args[outArg]
+	return fileResult, fullRisk
+}
 
-	if !dirExists || !outExists {
-		fmt.Println("Both '--dir' and '--out' need to be set. Exiting.")
-		return
+// Scores a single file on demand, outside of any walk, with the same rules as the scan.
+// Unlike the scan, small files are scored too.
+func AssessFile(path string) (FileResult, error) {
+	absolutePath, errAbs := filepath.Abs(path)
+	if errAbs != nil {
+		return FileResult{}, errAbs
 	}
 
-	var finalResult DirResult
+	info, errStat := os.Stat(absolutePath)
+	if errStat != nil {
+		return FileResult{}, errStat
+	}
+	if info.IsDir() {
+		return FileResult{}, fmt.Errorf("%v is a directory", absolutePath)
+	}
+
+	return scoreFile(absolutePath, info), nil
+}
+
+// Scores a set of files concurrently, with the same rules as the scan and the filters, archive propagation and hashes
+// of opts (DefaultOptions() leaves the small files out). The results and the errors are in the order of the paths, each
+// path has either its result or its error, a filtered file having an error telling why it was skipped.
+func AssessPaths(paths []string, opts Options) ([]FileResult, []error) {
+	results := make([]FileResult, len(paths))
+	errs := make([]error, len(paths))
+
+	indexes := make(chan int)
+	var workers sync.WaitGroup
+	for worker := 0; worker < runtime.NumCPU(); worker++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for i := range indexes {
+				results[i], errs[i] = assessPath(paths[i], opts)
+			}
+		}()
+	}
+	for i := range paths {
+		indexes <- i
+	}
+	close(indexes)
+	workers.Wait()
+
+	return results, errs
+}
+
+// Scores one of the files of AssessPaths
+func assessPath(path string, opts Options) (FileResult, error) {
+	absolutePath, errAbs := filepath.Abs(path)
+	if errAbs != nil {
+		return FileResult{}, errAbs
+	}
+	info, errStat := os.Lstat(absolutePath)
+	if errStat != nil {
+		return FileResult{}, errStat
+	}
+	if info.IsDir() {
+		return FileResult{}, fmt.Errorf("%v is a directory", absolutePath)
+	}
+	if reason := skipReason(absolutePath, info, opts); reason != "" {
+		return FileResult{}, fmt.Errorf("%v skipped: %v", absolutePath, reason)
+	}
+
+	result := scoreFile(absolutePath, info)
+	if opts.ArchivePropagate {
+		if errArchive := propagateArchiveRisk(&result); errArchive != nil {
+			return FileResult{}, fmt.Errorf("reading archive %v: %w", absolutePath, errArchive)
+		}
+	}
+	if opts.Hash != "" && info.Mode().IsRegular() && info.Size() <= opts.HashMaxSize {
+		hash, errHash := hashFile(absolutePath, opts.Hash)
+		if errHash != nil {
+			return FileResult{}, fmt.Errorf("hashing %v: %w", absolutePath, errHash)
+		}
+		result.Hash = hash
+	}
+	return result, nil
+}
+
+// Scores a single file like the walk and writes how: the risk of each rule with the running total, then the cap on
+// the reductions, the zone, the bounds and the always max extensions when they change it ('--explain-file').
+// The entries of its directory are counted for the CrowdedDir rule.
+func explainFile(writer io.Writer, path string, context fileContext) error {
+	absolutePath, errAbs := filepath.Abs(path)
+	if errAbs != nil {
+		return errAbs
+	}
+	info, errStat := os.Stat(absolutePath)
+	if errStat != nil {
+		return errStat
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%v is a directory", absolutePath)
+	}
+
+	entries, errCount := countDirEntries(filepath.Dir(absolutePath))
+	if errCount != nil {
+		return errCount
+	}
+	context.dirEntries = entries
+
+	result, sum := scoreFileSteps(absolutePath, info, context)
+	fmt.Fprintln(writer, absolutePath)
+	running := 0.0
+	for _, step := range sum.steps {
+		running += step.risk
+		fmt.Fprintf(writer, "  %-24v %+.4f  %.4f\n", step.rule, step.risk, running)
+	}
+	if maxReduction >= 0 && sum.removed > maxReduction {
+		fmt.Fprintf(writer, "  %-24v %+.4f  %.4f\n", "reductions capped", sum.removed-maxReduction, sum.total())
+	}
+	risk := sum.total()
+	if _, factor, inZone := pathZone(absolutePath); inZone {
+		fmt.Fprintf(writer, "  %-24v %+.4f  %.4f\n", fmt.Sprintf("zone x%v", factor), risk*factor-risk, risk*factor)
+		risk *= factor
+	}
+	if clamped := checkRiskRange(risk); clamped != risk {
+		fmt.Fprintf(writer, "  %-24v %+.4f  %.4f\n", "bounded", clamped-risk, clamped)
+	}
+	if checkRiskRange(risk) != result.Risk {
+		fmt.Fprintf(writer, "  %-24v %+.4f  %.4f\n", "always max extension", result.Risk-checkRiskRange(risk), result.Risk)
+	}
+	fmt.Fprintf(writer, "risk %.4f (%v)\n", result.Risk, result.Label)
+	return nil
+}
+
+// File information of data held in memory, which has no mode nor system data
+type memoryFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (m memoryFileInfo) Name() string       { return filepath.Base(m.name) }
+func (m memoryFileInfo) Size() int64        { return m.size }
+func (m memoryFileInfo) Mode() fs.FileMode  { return 0 }
+func (m memoryFileInfo) ModTime() time.Time { return m.modTime }
+func (m memoryFileInfo) IsDir() bool        { return false }
+func (m memoryFileInfo) Sys() any           { return nil }
+
+// Scores data already in memory (an uploaded file...) as if it were a file with this name, without touching the disk.
+// The name and size rules apply as in the scan, and a content not matching the extension is noted.
+func AssessBytes(name string, data []byte, modTime time.Time) FileResult {
+	result := scoreFile(name, memoryFileInfo{name: name, size: int64(len(data)), modTime: modTime})
+
+	extension := strings.ToLower(filepath.Ext(name))
+	if expected, known := extensionContentTypes[extension]; known {
+		if detected := sniffContentType(data); detected != expected {
+			result.Notes = append(result.Notes, mismatchNote(detected, extension))
+		}
+	}
+	return result
+}
+
+// Lists a directory by batches of dirBatchSize entries, each batch sorted by name
+type dirListing struct {
+	file  *os.File
+	batch []fs.DirEntry
+	next  int
+	done  bool
+	err   error
+}
+
+// Opens a directory and reads its first batch, so a directory that can't be listed fails here
+func openDirListing(path string) (*dirListing, error) {
+	file, errOpen := os.Open(path)
+	if errOpen != nil {
+		return nil, errOpen
+	}
+	listing := &dirListing{file: file}
+	if listing.read(); listing.err != nil {
+		file.Close()
+		return nil, listing.err
+	}
+	return listing, nil
+}
+
+// Reads the next batch of entries
+func (listing *dirListing) read() {
+	entries, errRead := listing.file.ReadDir(dirBatchSize)
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	listing.batch, listing.next = entries, 0
+	if errRead == io.EOF {
+		listing.done = true
+	} else if errRead != nil {
+		listing.done, listing.err = true, errRead
+	}
+}
+
+// Gives the next entry of the directory, false once they were all given or the listing failed
+func (listing *dirListing) entry() (fs.DirEntry, bool) {
+	for listing.next == len(listing.batch) {
+		if listing.done {
+			return nil, false
+		}
+		listing.read()
+	}
+	listing.next++
+	return listing.batch[listing.next-1], true
+}
+
+// Closes the directory, once its entries were walked
+func (listing *dirListing) close() {
+	listing.file.Close()
+}
+
+// Counts the entries of a directory by batches, without keeping them
+func countDirEntries(path string) (int, error) {
+	file, errOpen := os.Open(path)
+	if errOpen != nil {
+		return 0, errOpen
+	}
+	defer file.Close()
+	count := 0
+	for {
+		entries, errRead := file.ReadDir(dirBatchSize)
+		count += len(entries)
+		if errRead == io.EOF {
+			return count, nil
+		}
+		if errRead != nil {
+			return count, errRead
+		}
+	}
+}
+
+// Assess the risk of a directory.
+// The ignore patterns of the parent directories are given, the ones from the .walkscanignore of this directory are added to them.
+func assessDirRisk(path string, parentIgnores []ignorePattern, state *scanState) []FileResult {
+
+	var finalResult []FileResult
+
+	// Only the riskiest files of this dir are kept while walking it
+	currentDirResults := newTopResults(state.options.TopPerDir)
+	scoredBefore := state.summary.ScoredFiles
+
+	// The entries are only named and typed, the information of a file is read once it isn't filtered out by its name.
+	// They are read by batches, a huge directory is never held whole in memory.
+	var listing *dirListing
+	errReadDir := state.retry(func() (err error) {
+		listing, err = openDirListing(path)
+		return err
+	})
+	// Only the crowded directories rule needs the number of entries before the walk, they are counted apart for it
+	dirEntries := 0
+	if errReadDir == nil && weights.CrowdedDir != 0 {
+		if dirEntries, errReadDir = countDirEntries(path); errReadDir != nil {
+			listing.close()
+		}
+	}
+	if errReadDir == nil {
+		localIgnores, errIgnore := readIgnoreFile(filepath.Join(path, ignoreFileName), path)
+		if errIgnore != nil && !errors.Is(errIgnore, fs.ErrNotExist) {
+			state.warn(path, "reading ignore file", errIgnore)
+		}
+		ignores := append(append([]ignorePattern{}, parentIgnores...), localIgnores...)
+
+		for {
+			dir, more := listing.entry()
+			if !more || state.err != nil {
+				break
+			}
+
+			absName := path + string(os.PathSeparator) + dir.Name()
+			if !dir.IsDir() {
+				state.summary.seenFiles++
+			}
+
+			subdirIgnores := ignores
+			if isIgnored(absName, ignores) {
+				// A negation may include again a file below an ignored directory, so it is walked with everything in it ignored
+				if !dir.IsDir() || !hasNegation(ignores) {
+					dirInfo, _ := dir.Info()
+					state.record(absName, dirInfo, "ignored", "")
+					continue
+				}
+				subdirIgnores = append([]ignorePattern{{base: absName, pattern: "*"}}, ignores...)
+			}
+
+			// Out of the wanted extensions, a file is skipped before it costs a stat ('--only-ext')
+			if len(state.options.OnlyExtensions) > 0 && dir.Type()&(fs.ModeDir|fs.ModeSymlink) == 0 &&
+				!slices.Contains(state.options.OnlyExtensions, strings.ToLower(filepath.Ext(dir.Name()))) {
+				state.record(absName, nil, "skipped", "extension not in "+onlyExtArg)
+				continue
+			}
+
+			var fileInfo fs.FileInfo
+			errLstat := state.retry(func() (err error) {
+				fileInfo, err = os.Lstat(absName)
+				return err
+			})
+
+			if errLstat == nil {
+				isDir := fileInfo.IsDir()
+				if state.options.FollowDirSymlinks && fileInfo.Mode()&fs.ModeSymlink != 0 {
+					var reason string
+					if isDir, reason = state.followDirLink(absName, path); reason != "" {
+						state.record(absName, fileInfo, "skipped", reason)
+						continue
+					}
+				}
+				if isDir {
+					if state.options.SkipHiddenDirs && strings.HasPrefix(dir.Name(), ".") {
+						state.record(absName, fileInfo, "skipped", "hidden directory")
+						continue
+					}
+					if state.options.SkipVcs && vcsDirNames[dir.Name()] {
+						state.record(absName, fileInfo, "skipped", "version control directory")
+						continue
+					}
+					dirResults := assessDirRisk(absName, subdirIgnores, state)
+					for _, res := range dirResults {
+						finalResult = append(finalResult, res)
+					}
+				} else if reason := skipReason(absName, fileInfo, state.options); reason != "" {
+					state.record(absName, fileInfo, "skipped", reason)
+				} else if state.sample != nil && state.sample.Float64()*100 >= state.options.SamplePercent {
+					// A quick audit only scores a part of the files ('--sample')
+					state.record(absName, fileInfo, "skipped", "not in the sample")
+				} else {
+					scoringStart := time.Now()
+					// A rule panicking on a malformed file only loses that file, the scan goes on
+					var inputs fileInputs
+					fileResult, skipped, errScore := safeScore(func() (FileResult, string) {
+						var mismatchNote string
+						if state.options.OnlyMismatchedType {
+							var mismatched bool
+							if mismatchNote, mismatched = typeMismatch(absName); !mismatched {
+								return FileResult{}, "content matches its extension"
+							}
+						}
+						// An unchanged file keeps the risks of the rules on the file itself from the previous scan ('--cache')
+						var cached bool
+						if inputs, cached = state.cachedInputs(absName, fileInfo); !cached {
+							inputs = scoreFileInputs(absName, fileInfo)
+							if state.options.ArchivePropagate {
+								var errArchive error
+								if inputs.archive, errArchive = scoreArchive(absName); errArchive != nil {
+									state.warn(absName, "reading archive", errArchive)
+								}
+							}
+						}
+						fileResult, _ := scoreInContext(absName, fileInfo, fileContext{dirEntries: dirEntries, maxDirEntries: state.options.MaxFilesPerDir,
+							previous: state.previous}, inputs)
+						if mismatchNote != "" {
+							fileResult.Notes = append(fileResult.Notes, mismatchNote)
+						}
+						raiseArchiveRisk(&fileResult, inputs.archive)
+						return fileResult, ""
+					})
+					state.scoring += time.Since(scoringStart)
+					if errScore != nil {
+						state.record(absName, fileInfo, "error", errScore.Error())
+						state.warn(absName, "scoring", errScore)
+						continue
+					}
+					if skipped != "" {
+						state.record(absName, fileInfo, "skipped", skipped)
+						continue
+					}
+					state.cacheInputs(absName, fileInfo, inputs)
+					state.summary.add(fileResult)
+					if state.policy != nil {
+						state.violations = append(state.violations, state.policy.check(fileResult)...)
+					}
+					// Hunting regressions, only the files riskier than in the baseline are reported
+					if state.baseline != nil && !state.baseline.regressed(&fileResult) {
+						state.record(absName, fileInfo, "skipped", "risk not increased since "+regressionsArg)
+						continue
+					}
+					currentDirResults.add(fileResult)
+					state.record(absName, fileInfo, "scored", "")
+				}
+			} else {
+				// The file may have been deleted since the dir was listed, or can't be accessed
+				state.record(absName, nil, "error", errLstat.Error())
+				switch state.options.OnError {
+				case "skip":
+					// Left out of the report, but still told on stderr unless '--quiet'
+					state.logger.Info("skipped a file that can't be stat'ed", "path", absName, "error", errLstat)
+				case "warn":
+					state.warn(absName, "getting file info", errLstat)
+				case "fail":
+					state.err = fmt.Errorf("getting file info: %w", errLstat)
+				}
+			}
+		}
+		listing.close()
+		if listing.err != nil {
+			state.record(path, nil, "error", listing.err.Error())
+			state.warn(path, "list dirs", listing.err)
+		}
+	} else {
+		state.record(path, nil, "error", errReadDir.Error())
+		state.warn(path, "list dirs", errReadDir)
+	}
+
+	// Keep the 10 (or '--top-per-dir') riskiest files for this dir, the subdirs have their own
+	dirResults := currentDirResults.list()
 
-	absoluteDir, _ := filepath.Abs(rootDir)
-	finalResult.Dir = absoluteDir
+	// Only the kept files are hashed, hashing every scored file would read the whole tree
+	if state.options.Hash != "" {
+		addHashes(dirResults, state)
+	}
+	if state.options.GitBlame {
+		addGitCommits(dirResults)
+	}
+
+	// Nothing was scored in this dir or below, which may be worth a look in an audit
+	if state.options.ReportEmptyDirs && errReadDir == nil && state.summary.ScoredFiles == scoredBefore {
+		dirResults = append(dirResults, emptyDirResult(path))
+	}
 
-	dirResults := assessDirRisk(absoluteDir)
+	outputStart := time.Now()
+	if state.outputDir != "" {
+		writeDirFile(state, path, dirResults)
+		state.output += time.Since(outputStart)
+		return finalResult
+	}
+	if state.stream != nil {
+		writeDirRecord(state, path, dirResults)
+		state.output += time.Since(outputStart)
+		return finalResult
+	}
+	if state.array != nil {
+		relativePaths(dirResults, state.options.RelativeTo)
+		for _, res := range dirResults {
+			if errWrite := state.array.write(res); errWrite != nil {
+				state.warn(res.Path, "writing the result of "+res.Path, errWrite)
+			}
+		}
+		state.output += time.Since(outputStart)
+		return finalResult
+	}
+	if state.global != nil {
+		for _, res := range dirResults {
+			state.global.Add(res)
+		}
+		return finalResult
+	}
 	for _, res := range dirResults {
-		finalResult.Results = append(finalResult.Results, res)
+		finalResult = append(finalResult, res)
+	}
+
+	return finalResult
+}
+
+// The zero risk result reporting a directory without scored files. It isn't counted in the summary.
+func emptyDirResult(path string) FileResult {
+	result := FileResult{Path: path, Risk: minRisk, Label: riskBand(minRisk), Notes: []string{"no scored file"}}
+	if info, errStat := os.Lstat(path); errStat == nil {
+		result.ModTime = info.ModTime()
+	}
+	return result
+}
+
+// Tells why a file found during the walk isn't scored, empty when it gets scored
+func skipReason(path string, info fs.FileInfo, options Options) string {
+	// Devices, pipes and sockets aren't files anyone left behind, and reading them can block forever
+	if !options.IncludeSpecialFiles && !info.Mode().IsRegular() && info.Mode()&fs.ModeSymlink == 0 {
+		return "not a regular file"
+	}
+
+	// Out of the wanted extensions ('--only-ext'). The walk already skipped the other files before stating them, but
+	// not the symlinks, which may point to directories, nor the paths given to AssessPaths.
+	if len(options.OnlyExtensions) > 0 && !info.IsDir() && !slices.Contains(options.OnlyExtensions, strings.ToLower(filepath.Ext(path))) {
+		return "extension not in " + onlyExtArg
+	}
+
+	// Out of the size window, the file is left out before anything else
+	if options.ExcludeLargerThan >= 0 && info.Size() > options.ExcludeLargerThan {
+		return "larger than " + excludeLargerArg
+	}
+	if options.ExcludeSmallerThan >= 0 && info.Size() < options.ExcludeSmallerThan {
+		return "smaller than " + excludeSmallerArg
+	}
+
+	// Files older than '--since' were covered by a previous sweep
+	if !options.Since.IsZero() && !info.ModTime().After(options.Since) {
+		return "not modified since " + sinceArg
+	}
+
+	if inIgnoredSizeRange(info.Size(), options) {
+		return "in the ignored size range"
+	}
+
+	// In a scan of our own files, the ones we created ourselves are noise
+	if options.IgnoreOwn {
+		if owner, ok := fileOwner(info); ok && os.Getuid() >= 0 && owner == uint64(os.Getuid()) {
+			return "owned by the current user"
+		}
+	}
+
+	// Empty files hold nothing, even when the size floor is off
+	if options.IgnoreEmpty && info.Mode().IsRegular() && info.Size() == 0 {
+		return "empty file"
+	}
+
+	// Broken symlinks are always reported
+	if isBrokenLink(path, info) {
+		return ""
+	}
+
+	// If the file size is lower than 1 KB (or the '--size-floor') ignore it.
+	if !options.NoSizeFloor && info.Size() <= options.SizeFloor {
+		return "not larger than the size floor"
+	}
+
+	// Generated files carry a marker in their header. Last, as the only check reading the file.
+	if options.ExcludeIfContains != nil && info.Mode().IsRegular() && headContains(path, options.ExcludeIfContains, options.ExcludeIfContainsBytes) {
+		return "contains " + excludeIfContainsArg
+	}
+	return ""
+}
+
+// Tells if the first bytes of a file match a pattern, false when it can't be read
+func headContains(path string, pattern *regexp.Regexp, size int64) bool {
+	file, errOpen := os.Open(path)
+	if errOpen != nil {
+		return false
+	}
+	defer file.Close()
+
+	head, errRead := io.ReadAll(io.LimitReader(file, size))
+	if errRead != nil {
+		return false
+	}
+	return pattern.Match(head)
+}
+
+// Sets the content hash of the results, skipping what isn't a regular file or is larger than the hash limit
+func addHashes(results []FileResult, state *scanState) {
+	for i := range results {
+		info, errStat := os.Stat(results[i].Path)
+		if errStat != nil || !info.Mode().IsRegular() || info.Size() > state.options.HashMaxSize {
+			continue
+		}
+
+		fileHash, errHash := hashFile(results[i].Path, state.options.Hash)
+		if errHash != nil {
+			state.warn(results[i].Path, "hashing file", errHash)
+			continue
+		}
+		results[i].Hash = fileHash
+	}
+}
+
+func newGitStatusCache() *gitStatusCache {
+	return &gitStatusCache{repos: make(map[string]string), untracked: make(map[string]map[string]bool)}
+}
+
+// Tells if a file is in a git repository without being tracked, false out of any repository or when git fails
+func (cache *gitStatusCache) isUntracked(path string) bool {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	dir := filepath.Dir(path)
+	repo, known := cache.repos[dir]
+	if !known {
+		output, errGit := exec.Command("git", "-C", dir, "rev-parse", "--show-toplevel").Output()
+		if errGit == nil {
+			repo = filepath.Clean(strings.TrimSpace(string(output)))
+		}
+		cache.repos[dir] = repo
+	}
+	if repo == "" {
+		return false
+	}
+
+	files, listed := cache.untracked[repo]
+	if !listed {
+		files = make(map[string]bool)
+		output, errGit := exec.Command("git", "-C", repo, "ls-files", "--others", "-z").Output()
+		if errGit == nil {
+			for _, name := range strings.Split(string(output), "\x00") {
+				if name != "" {
+					files[filepath.Join(repo, filepath.FromSlash(name))] = true
+				}
+			}
+		}
+		cache.untracked[repo] = files
+	}
+	return files[path]
+}
+
+// Sets the last commit of the results tracked by git. The files out of a repository or not committed yet have none.
+func addGitCommits(results []FileResult) {
+	for i := range results {
+		results[i].Git = lastCommit(results[i].Path)
+	}
+}
+
+// Gives the last commit changing a file, nil when git doesn't know it
+func lastCommit(path string) *GitCommit {
+	command := exec.Command("git", "-C", filepath.Dir(path), "log", "-1", "--format=%H%x00%an%x00%aI", "--", filepath.Base(path))
+	output, errGit := command.Output()
+	if errGit != nil {
+		return nil
+	}
+	fields := strings.Split(strings.TrimSpace(string(output)), "\x00")
+	if len(fields) != 3 {
+		return nil
+	}
+	date, errDate := time.Parse(time.RFC3339, fields[2])
+	if errDate != nil {
+		return nil
+	}
+	return &GitCommit{Commit: fields[0], Author: fields[1], Date: date}
+}
+
+// Hashes the content of a file with md5 or sha256, returning "<algorithm>:<hex digest>"
+func hashFile(path string, algorithm string) (string, error) {
+	var hasher hash.Hash
+	switch algorithm {
+	case "md5":
+		hasher = md5.New()
+	case "sha256":
+		hasher = sha256.New()
+	default:
+		return "", fmt.Errorf("unknown hash algorithm %q, expected md5 or sha256", algorithm)
+	}
+
+	file, errOpen := os.Open(path)
+	if errOpen != nil {
+		return "", errOpen
+	}
+	defer file.Close()
+
+	if _, errCopy := io.Copy(hasher, file); errCopy != nil {
+		return "", errCopy
+	}
+
+	return algorithm + ":" + hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// Guesses the type of a file from its first bytes: one of magicNumbers, text or binary
+func detectContentType(path string) (string, error) {
+	file, errOpen := os.Open(path)
+	if errOpen != nil {
+		return "", errOpen
 	}
+	defer file.Close()
 
-	// TODO probably better to check if file exists or not
-	outFile, fileOpenErr := os.OpenFile(outFileName, os.O_CREATE, os.ModePerm)
-	defer outFile.Close()
+	head := make([]byte, 512)
+	read, errRead := io.ReadFull(file, head)
+	if errRead != nil && errRead != io.ErrUnexpectedEOF {
+		return "", errRead
+	}
+	return sniffContentType(head[:read]), nil
+}
+
+// Guesses a content type from the first bytes of some data: one of magicNumbers, text or binary
+func sniffContentType(head []byte) string {
+	if len(head) > 512 {
+		head = head[:512]
+	}
+	for _, magic := range magicNumbers {
+		if bytes.HasPrefix(head, magic.magic) {
+			return magic.contentType
+		}
+	}
+	if bytes.IndexByte(head, 0) >= 0 {
+		return "binary"
+	}
+	return "text"
+}
+
+// Checks if the content of a file doesn't match its extension (a renamed archive, a script disguised as text...),
+// and gives a note telling what it looks like. Extensions without an expected type and unreadable files never mismatch.
+func typeMismatch(path string) (string, bool) {
+	extension := strings.ToLower(filepath.Ext(path))
+	expected, known := extensionContentTypes[extension]
+	if !known || !isRegularFile(path) {
+		return "", false
+	}
+	detected, errDetect := detectContentType(path)
+	if errDetect != nil || detected == expected {
+		return "", false
+	}
+	return mismatchNote(detected, extension), true
+}
+
+// Tells what the content of a file looks like instead of what its extension says
+func mismatchNote(detected string, extension string) string {
+	return fmt.Sprintf("content looks like %v, not %v", detected, extension)
+}
+
+// Checks if a path is a regular file, following symlinks. Reading anything else (a named pipe...) can block.
+func isRegularFile(path string) bool {
+	info, errStat := os.Stat(path)
+	return errStat == nil && info.Mode().IsRegular()
+}
+
+// Gives the format of an archive from its extension: zip, tar or tgz, empty for other files
+func archiveFormat(path string) string {
+	lowerPath := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(lowerPath, ".tar.gz"), strings.HasSuffix(lowerPath, ".tgz"):
+		return "tgz"
+	case strings.HasSuffix(lowerPath, ".tar"):
+		return "tar"
+	}
+	switch filepath.Ext(lowerPath) {
+	case ".zip", ".jar", ".war", ".ear":
+		return "zip"
+	}
+	return ""
+}
+
+// Lists the names of the files in an archive, the directories are left out
+func listArchive(path string, format string) ([]string, error) {
+	var names []string
+	if format == "zip" {
+		reader, errOpen := zip.OpenReader(path)
+		if errOpen != nil {
+			return nil, errOpen
+		}
+		defer reader.Close()
+		for _, entry := range reader.File {
+			if !entry.FileInfo().IsDir() {
+				names = append(names, entry.Name)
+			}
+		}
+		return names, nil
+	}
+
+	file, errOpen := os.Open(path)
+	if errOpen != nil {
+		return nil, errOpen
+	}
+	defer file.Close()
+
+	var content io.Reader = file
+	if format == "tgz" {
+		gzipReader, errGzip := gzip.NewReader(file)
+		if errGzip != nil {
+			return nil, errGzip
+		}
+		defer gzipReader.Close()
+		content = gzipReader
+	}
+	tarReader := tar.NewReader(content)
+	for {
+		header, errNext := tarReader.Next()
+		if errNext == io.EOF {
+			return names, nil
+		}
+		if errNext != nil {
+			return names, errNext
+		}
+		if header.Typeflag == tar.TypeReg {
+			names = append(names, header.Name)
+		}
+	}
+}
+
+// Raises the risk of an archive to the one of its riskiest entry, so a zip full of secrets scores high.
+// The entries are scored on their name only (extension and sensitive names), they aren't extracted.
+func propagateArchiveRisk(result *FileResult) error {
+	archive, errArchive := scoreArchive(result.Path)
+	if errArchive != nil {
+		return errArchive
+	}
+	raiseArchiveRisk(result, archive)
+	return nil
+}
+
+// Scores the entries of an archive on their names, giving the risky ones. Nothing for the other files, nor for an
+// archive without risky entries.
+func scoreArchive(path string) (*ArchiveResult, error) {
+	format := archiveFormat(path)
+	if format == "" || !isRegularFile(path) {
+		return nil, nil
+	}
+	names, errList := listArchive(path, format)
+	if errList != nil {
+		return nil, errList
+	}
+	secretEntries, errSecrets := javaArchiveSecrets(path)
+	if errSecrets != nil {
+		return nil, errSecrets
+	}
+
+	archive := &ArchiveResult{Format: format}
+	for _, name := range names {
+		var entryRisk riskSum
+		var notes []string
+		entryRisk.add("Extension", assessExtension(name))
+		entryRisk.add("SensitiveName", assessFileName(name))
+		// A password left in the configuration of a build artifact is as bad as a sensitive file
+		if slices.Contains(secretEntries, name) {
+			entryRisk.add("SensitiveName", weights.SensitiveName)
+			notes = append(notes, "sets a password or a secret")
+		}
+		risk := checkRiskRange(entryRisk.total())
+		if risk > minRisk {
+			archive.Entries = append(archive.Entries, ArchiveEntry{Path: name, Risk: risk, Notes: notes})
+		}
+	}
+	if len(archive.Entries) == 0 {
+		return nil, nil
+	}
+	return archive, nil
+}
 
-	if nil != fileOpenErr {
-		fmt.Printf("Error while opening the output file: %v\n", fileOpenErr)
+// Raises the risk of an archive to the one of its riskiest entry, keeping its risky entries in its result
+func raiseArchiveRisk(result *FileResult, archive *ArchiveResult) {
+	if archive == nil {
 		return
 	}
+	result.Archive = archive
+	riskiest := archive.Entries[0]
+	for _, entry := range archive.Entries[1:] {
+		if entry.Risk > riskiest.Risk {
+			riskiest = entry
+		}
+	}
+	if riskiest.Risk > result.Risk {
+		result.Risk = riskiest.Risk
+		result.Label = riskBand(riskiest.Risk)
+		result.Notes = append(result.Notes, fmt.Sprintf("contains %v", riskiest.Path))
+	}
+}
+
+// Gives the .properties entries of a Java archive (.jar, .war, .ear) that set a password or a secret, other archives
+// give nothing. Only the first maxPropertiesSize bytes of an entry are read, so a zip bomb can't fill the memory, and
+// the entries that can't be read are left out.
+func javaArchiveSecrets(path string) ([]string, error) {
+	if !slices.Contains(javaArchiveExtensions, strings.ToLower(filepath.Ext(path))) {
+		return nil, nil
+	}
+	reader, errOpen := zip.OpenReader(path)
+	if errOpen != nil {
+		return nil, errOpen
+	}
+	defer reader.Close()
+
+	var entries []string
+	for _, entry := range reader.File {
+		if entry.FileInfo().IsDir() || !strings.EqualFold(filepath.Ext(entry.Name), ".properties") {
+			continue
+		}
+		content, errRead := readArchiveEntry(entry, maxPropertiesSize)
+		if errRead != nil {
+			continue
+		}
+		if hasSecretProperty(content) {
+			entries = append(entries, entry.Name)
+		}
+	}
+	return entries, nil
+}
 
-	writeJsonToFile(outFile, finalResult)
+// Reads at most size bytes of a zip entry
+func readArchiveEntry(entry *zip.File, size int64) ([]byte, error) {
+	content, errOpen := entry.Open()
+	if errOpen != nil {
+		return nil, errOpen
+	}
+	defer content.Close()
+	return io.ReadAll(io.LimitReader(content, size))
+}
 
+// Checks if a Java properties content sets a non-empty value to a key naming a password or a secret (db.password=...)
+func hasSecretProperty(content []byte) bool {
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+			continue
+		}
+		separator := strings.IndexAny(line, "=:")
+		if separator < 0 {
+			continue
+		}
+		key := strings.ToLower(line[:separator])
+		value := strings.TrimSpace(line[separator+1:])
+		if value != "" && (strings.Contains(key, "password") || strings.Contains(key, "secret")) {
+			return true
+		}
+	}
+	return false
+}
+
+// Gives the inode number of a file, when the system has one (the Ino of syscall.Stat_t, missing on Windows)
+func fileInode(info fs.FileInfo) (uint64, bool) {
+	sys := reflect.ValueOf(info.Sys())
+	if sys.Kind() == reflect.Pointer {
+		sys = sys.Elem()
+	}
+	if sys.Kind() != reflect.Struct {
+		return 0, false
+	}
+	inode := sys.FieldByName("Ino")
+	if !inode.IsValid() || !inode.CanUint() {
+		return 0, false
+	}
+	return inode.Uint(), true
+}
+
+// Reads the manifest of a previous scan, one json entry per line
+func readManifest(fileName string) (*previousManifest, error) {
+	file, errOpen := os.Open(fileName)
+	if errOpen != nil {
+		return nil, errOpen
+	}
+	defer file.Close()
+
+	previous := &previousManifest{entries: make(map[string]ManifestEntry), paths: make(map[uint64]string)}
+	decoder := json.NewDecoder(file)
+	for {
+		var entry ManifestEntry
+		errDecode := decoder.Decode(&entry)
+		if errDecode == io.EOF {
+			return previous, nil
+		}
+		if errDecode != nil {
+			return nil, fmt.Errorf("%v: %w", fileName, errDecode)
+		}
+		previous.entries[entry.Path] = entry
+		if entry.Inode != 0 {
+			previous.paths[entry.Inode] = entry.Path
+		}
+	}
+}
+
+// Reads a policy file. The directories of the rules are made absolute and their extensions lower case.
+func readPolicy(fileName string) (*Policy, error) {
+	file, errOpen := openConfig(fileName)
+	if errOpen != nil {
+		return nil, errOpen
+	}
+	defer file.Close()
+
+	var policy Policy
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if errDecode := decoder.Decode(&policy); errDecode != nil {
+		return nil, fmt.Errorf("%v: %w", fileName, errDecode)
+	}
+	for i := range policy.Rules {
+		rule := &policy.Rules[i]
+		if rule.Name == "" || (rule.MaxRisk == nil && !rule.Forbidden) {
+			return nil, fmt.Errorf("%v: rule %v needs a Name, and a MaxRisk or Forbidden", fileName, i+1)
+		}
+		if rule.Under != "" {
+			var errAbs error
+			if rule.Under, errAbs = realPath(rule.Under); errAbs != nil {
+				return nil, fmt.Errorf("%v: rule %q: %w", fileName, rule.Name, errAbs)
+			}
+		}
+		for j, extension := range rule.Extensions {
+			rule.Extensions[j] = strings.ToLower(normalizeExtension(extension))
+		}
+	}
+	return &policy, nil
+}
+
+// Gives the rules of the policy a scored file breaks
+func (policy *Policy) check(result FileResult) []Violation {
+	var violations []Violation
+	for _, rule := range policy.Rules {
+		if rule.Under != "" && result.Path != rule.Under && !strings.HasPrefix(result.Path, rule.Under+string(os.PathSeparator)) {
+			continue
+		}
+		if len(rule.Extensions) > 0 && !slices.Contains(rule.Extensions, strings.ToLower(filepath.Ext(result.Path))) {
+			continue
+		}
+		if rule.Forbidden || result.Risk > *rule.MaxRisk {
+			violations = append(violations, Violation{Rule: rule.Name, Path: result.Path, Risk: result.Risk})
+		}
+	}
+	return violations
+}
+
+// Gives the absolute path of a directory with its symlinks resolved, as the walked roots are, so it can be compared
+// with the scanned paths. A directory that doesn't exist (yet) is only made absolute.
+func realPath(path string) (string, error) {
+	absolutePath, errAbs := filepath.Abs(path)
+	if errAbs != nil {
+		return "", errAbs
+	}
+	if resolved, errReal := filepath.EvalSymlinks(absolutePath); errReal == nil {
+		return resolved, nil
+	}
+	return absolutePath, nil
+}
+
+// Reads the cache written by a previous scan, one json entry per line. A missing cache is empty, and so is a cache
+// written by another version or scored with another configuration than the fingerprint: its risks would be wrong.
+func readCache(fileName string, fingerprint string) (map[string]CacheEntry, error) {
+	cache := make(map[string]CacheEntry)
+	file, errOpen := os.Open(fileName)
+	if errors.Is(errOpen, fs.ErrNotExist) {
+		return cache, nil
+	}
+	if errOpen != nil {
+		return nil, errOpen
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	var header cacheHeader
+	if errHeader := decoder.Decode(&header); errHeader == io.EOF || (errHeader == nil && (header.Version != cacheVersion || header.Fingerprint != fingerprint)) {
+		return cache, nil
+	} else if errHeader != nil {
+		return nil, fmt.Errorf("%v: %w", fileName, errHeader)
+	}
+	for {
+		var entry CacheEntry
+		errDecode := decoder.Decode(&entry)
+		if errDecode == io.EOF {
+			return cache, nil
+		}
+		if errDecode != nil {
+			return nil, fmt.Errorf("%v: %w", fileName, errDecode)
+		}
+		cache[entry.Path] = entry
+	}
+}
+
+// Tells how a file changed since the previous scan: its inode belonged to another path (the file was deleted and its
+// inode reused), or its size changed by more than half. Nothing when it didn't.
+func (previous *previousManifest) changes(path string, info fs.FileInfo) []string {
+	var notes []string
+	if inode, ok := fileInode(info); ok {
+		if previousPath, known := previous.paths[inode]; known && previousPath != path {
+			notes = append(notes, "inode was "+previousPath+" in the previous scan")
+		}
+	}
+	if entry, known := previous.entries[path]; known && entry.Size > 0 {
+		change := float64(info.Size()-entry.Size) / float64(entry.Size)
+		if change > 0.5 || change < -0.5 {
+			notes = append(notes, fmt.Sprintf("size changed from %v to %v since the previous scan", entry.Size, info.Size()))
+		}
+	}
+	return notes
+}
+
+// Gives the uid of the owner of a file, when the system has one (the Uid of syscall.Stat_t, missing on Windows)
+func fileOwner(info fs.FileInfo) (uint64, bool) {
+	sys := reflect.ValueOf(info.Sys())
+	if sys.Kind() == reflect.Pointer {
+		sys = sys.Elem()
+	}
+	if sys.Kind() != reflect.Struct {
+		return 0, false
+	}
+	uid := sys.FieldByName("Uid")
+	if !uid.IsValid() || !uid.CanUint() {
+		return 0, false
+	}
+	return uid.Uint(), true
+}
+
+// Gives the creation time of a file, when the system keeps it: Birthtimespec on macOS, Birthtim on the BSDs,
+// CreationTime on Windows. Linux doesn't give it through os.Lstat.
+func fileBirthTime(info fs.FileInfo) (time.Time, bool) {
+	sys := reflect.ValueOf(info.Sys())
+	if sys.Kind() != reflect.Pointer || sys.Elem().Kind() != reflect.Struct {
+		return time.Time{}, false
+	}
+	sys = sys.Elem()
+
+	for _, name := range []string{"Birthtimespec", "Birthtim"} {
+		spec := sys.FieldByName(name)
+		if !spec.IsValid() || spec.Kind() != reflect.Struct {
+			continue
+		}
+		sec, nsec := spec.FieldByName("Sec"), spec.FieldByName("Nsec")
+		if sec.CanInt() && nsec.CanInt() {
+			return time.Unix(sec.Int(), nsec.Int()), true
+		}
+	}
+
+	if creation := sys.FieldByName("CreationTime"); creation.IsValid() && creation.CanAddr() {
+		nanoseconds := creation.Addr().MethodByName("Nanoseconds")
+		if nanoseconds.IsValid() {
+			return time.Unix(0, nanoseconds.Call(nil)[0].Int()), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Checks if a size is in the '--ignore-size-min' / '--ignore-size-max' range
+func inIgnoredSizeRange(size int64, options Options) bool {
+	if options.IgnoreSizeMin < 0 && options.IgnoreSizeMax < 0 {
+		return false
+	}
+	aboveMin := options.IgnoreSizeMin < 0 || size >= options.IgnoreSizeMin
+	belowMax := options.IgnoreSizeMax < 0 || size <= options.IgnoreSizeMax
+	return aboveMin && belowMax
+}
+
+// Checks if a path is ignored by the patterns. They are checked in order, the last matching one decides.
+func isIgnored(path string, ignores []ignorePattern) bool {
+	ignored := false
+	for _, ignore := range ignores {
+		target := filepath.Base(path)
+		if strings.ContainsRune(ignore.pattern, '/') {
+			relative, errRel := filepath.Rel(ignore.base, path)
+			if errRel != nil {
+				continue
+			}
+			target = filepath.ToSlash(relative)
+		}
+
+		pattern := ignore.pattern
+		if ignoreCase {
+			pattern, target = strings.ToLower(pattern), strings.ToLower(target)
+		}
+		if matched, _ := filepath.Match(pattern, target); matched {
+			ignored = !ignore.negate
+		}
+	}
+	return ignored
+}
+
+// Checks if one of the patterns is a negation
+func hasNegation(ignores []ignorePattern) bool {
+	for _, ignore := range ignores {
+		if ignore.negate {
+			return true
+		}
+	}
+	return false
+}
+
+// File information recorded in a previous report, so the rules can run without touching the disk. Without a mode nor
+// a Sys, the rules on symlinks, executables and creation times find nothing.
+type reportedFileInfo struct {
+	result FileResult
+}
+
+func (r reportedFileInfo) Name() string       { return filepath.Base(r.result.Path) }
+func (r reportedFileInfo) Size() int64        { return r.result.Size }
+func (r reportedFileInfo) Mode() fs.FileMode  { return 0 }
+func (r reportedFileInfo) ModTime() time.Time { return r.result.ModTime }
+func (r reportedFileInfo) IsDir() bool        { return false }
+func (r reportedFileInfo) Sys() any           { return nil }
+
+// Scores again the files of a previous report with the current rules and weights, using only the recorded paths, sizes and
+// modification times.
+// The input is either a report written by this program or JSON Lines of FileResult. The new results are added to the summary.
+func rescoreReport(fileName string, summary *Summary) (DirResult, error) {
+	var rescored DirResult
+
+	file, errOpen := os.Open(fileName)
+	if errOpen != nil {
+		return rescored, errOpen
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	for {
+		// A report (Dir + Results) or a single FileResult per line
+		var record json.RawMessage
+		errDecode := decoder.Decode(&record)
+		if errors.Is(errDecode, io.EOF) {
+			break
+		}
+		var entry struct {
+			Path    string
+			Dir     string
+			Results []FileResult
+		}
+		if errDecode == nil {
+			errDecode = json.Unmarshal(record, &entry)
+		}
+		if errDecode == nil && entry.Path != "" {
+			var result FileResult
+			errDecode = json.Unmarshal(record, &result)
+			entry.Results = append(entry.Results, result)
+		}
+		if errDecode != nil {
+			return rescored, fmt.Errorf("%v: %w", fileName, errDecode)
+		}
+
+		if entry.Dir != "" {
+			rescored.Dir = entry.Dir
+		}
+		for _, previous := range entry.Results {
+			result, _ := scoreFileSteps(previous.Path, reportedFileInfo{previous}, fileContext{reported: true})
+			rescored.Results = append(rescored.Results, result)
+		}
+	}
+
+	rescored.Summary = summary
+	for _, result := range rescored.Results {
+		rescored.Summary.seenFiles++
+		rescored.Summary.add(result)
+	}
+	rescored.Summary.finish()
+
+	return rescored, nil
+}
+
+/*
+===============
+Part three: Util functions
+===============
+*/
+
+// Initializes the risk values for all the extensions we check, grouped in categories. This should only be run once at the start of the application.
+// The values can be replaced by a json/csv file with '--ext-config', see loadExtensionConfig.
+func initExtensionConfig() ExtensionConfig {
+	config := ExtensionConfig{
+		Risks:      make(map[string]float64),
+		Categories: make(map[string]float64),
+		Extensions: make(map[string]string),
+	}
+
+	// If the file is an archive [zip, tar] → Add 0.15
+	config.Categories["archives"] = 0.15
+	config.Extensions[".zip"] = "archives"
+	config.Extensions[".tar"] = "archives"
+
+	// If the file is an image [png, jpeg] → Remove 0.20
+	config.Categories["images"] = -0.20
+	config.Extensions[".png"] = "images"
+	config.Extensions[".jpg"] = "images"
+	config.Extensions[".jpeg"] = "images"
+
+	// If the file holds data [csv, json] → Add 0.75
+	config.Categories["data"] = 0.75
+	config.Extensions[".csv"] = "data"
+	config.Extensions[".json"] = "data"
+
+	return config
+}
+
+// The scan settings when no argument changes them, which AssessPaths can start from
+func DefaultOptions() Options {
+	return Options{
+		SizeFloor:          defaultSizeFloor,
+		HashMaxSize:        defaultHashMaxSize,
+		TopPerDir:          maxResults,
+		IgnoreSizeMin:      -1,
+		IgnoreSizeMax:      -1,
+		ExcludeLargerThan:  -1,
+		ExcludeSmallerThan: -1,
+		OnError:            "skip",
+		MaxFilesPerDir:     defaultMaxFilesPerDir,
+
+		ExcludeIfContainsBytes: defaultContainsBytes,
+	}
+}
+
+// The risk bands when no '--bands' are given
+func defaultBands() []Band {
+	return []Band{{"low", 0.0}, {"medium", 0.3}, {"high", 0.7}}
+}
+
+// Reads the '--bands' value: comma separated label=min pairs, like "low=0,medium=0.3,high=0.7"
+func parseBands(value string) ([]Band, error) {
+	var parsed []Band
+	labels := make(map[string]bool)
+
+	for _, pair := range strings.Split(value, ",") {
+		label, minValue, found := strings.Cut(strings.TrimSpace(pair), "=")
+		if !found || label == "" {
+			return nil, fmt.Errorf("invalid band %q, expected label=min", pair)
+		}
+		min, errParse := strconv.ParseFloat(minValue, 64)
+		if errParse != nil {
+			return nil, fmt.Errorf("invalid band %q, expected label=min", pair)
+		}
+		if labels[label] {
+			return nil, fmt.Errorf("band %q is defined twice", label)
+		}
+		labels[label] = true
+		parsed = append(parsed, Band{Label: label, Min: min})
+	}
+
+	sort.Slice(parsed, func(i, j int) bool { return parsed[i].Min < parsed[j].Min })
+	return parsed, nil
+}
+
+// Reads the directories to scan from a file, one per line. Blank lines and lines starting with '#' are skipped.
+func readRootsFile(fileName string) ([]string, error) {
+	content, errRead := os.ReadFile(fileName)
+	if errRead != nil {
+		return nil, errRead
+	}
+
+	var roots []string
+	for _, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		roots = append(roots, line)
+	}
+
+	if len(roots) == 0 {
+		return nil, fmt.Errorf("%v doesn't list any directory", fileName)
+	}
+	return roots, nil
+}
+
+// Reads the glob patterns of an ignore file, one per line. Blank lines and lines starting with '#' are skipped.
+func readIgnoreFile(fileName string, base string) ([]ignorePattern, error) {
+	content, errRead := os.ReadFile(fileName)
+	if errRead != nil {
+		return nil, errRead
+	}
+
+	patterns, errPatterns := parseIgnorePatterns(strings.Split(string(content), "\n"), base)
+	if errPatterns != nil {
+		return nil, fmt.Errorf("%v: %w", fileName, errPatterns)
+	}
+	return patterns, nil
+}
+
+// Parses glob patterns relative to the base directory. Blank lines and lines starting with '#' are skipped.
+func parseIgnorePatterns(lines []string, base string) ([]ignorePattern, error) {
+	var patterns []ignorePattern
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		negate := strings.HasPrefix(line, "!")
+		line = strings.TrimPrefix(line, "!")
+		// A trailing slash only means "directory" in .gitignore, here any match is skipped
+		line = strings.TrimSuffix(line, "/")
+		if _, errPattern := filepath.Match(line, ""); errPattern != nil || line == "" {
+			return nil, fmt.Errorf("invalid pattern %q", line)
+		}
+		patterns = append(patterns, ignorePattern{base: base, pattern: line, negate: negate})
+	}
+
+	return patterns, nil
+}
+
+// The weights of the rules when no '--weights' file is given
+func defaultWeights() Weights {
+	return Weights{
+		LargeFile:               0.25,
+		RecentChange:            0.20,
+		SensitiveName:           0.75,
+		Extension:               1.0,
+		ShortDirName:            0.25,
+		MediumDirName:           0.5,
+		LongDirName:             -0.10,
+		BrokenLink:              0.10,
+		UnusualName:             0,
+		ConfusableName:          0,
+		RecentCreation:          0,
+		ExtensionlessExecutable: 0,
+		ManifestChange:          0.25,
+		CrowdedDir:              0,
+		Untracked:               0,
+	}
+}
+
+// Reads the weights from a json file. Weights missing from the file keep their default value.
+func loadWeights(fileName string, base Weights) (Weights, map[string]float64, error) {
+	loaded := base
+
+	file, errOpen := openConfig(fileName)
+	if errOpen != nil {
+		return loaded, nil, errOpen
+	}
+	defer file.Close()
+
+	// The weights are at the top level of the file, next to the Rules and Zones sections
+	content := struct {
+		Weights
+		Rules map[string]bool
+		Zones map[string]float64
+	}{Weights: base}
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if errDecode := decoder.Decode(&content); errDecode != nil {
+		return loaded, nil, fmt.Errorf("%v: %w", fileName, errDecode)
+	}
+	loaded = content.Weights
+
+	// The zones are matched against real absolute paths, like the scanned files
+	loadedZones := make(map[string]float64, len(content.Zones))
+	for zone, factor := range content.Zones {
+		if factor < 0 {
+			return loaded, nil, fmt.Errorf("%v: zone %q has a negative factor %v", fileName, zone, factor)
+		}
+		absoluteZone, errAbs := realPath(zone)
+		if errAbs != nil {
+			return loaded, nil, fmt.Errorf("%v: zone %q: %w", fileName, zone, errAbs)
+		}
+		loadedZones[absoluteZone] = factor
+	}
+
+	// A rule turned off weighs nothing, a rule turned on keeps its weight, or gets the one of enabledWeights when it
+	// weighs nothing (the rules off by default)
+	ruleWeights := loaded.rules()
+	enabled := enabledWeights()
+	enabledRuleWeights := enabled.rules()
+	for rule, on := range content.Rules {
+		ruleWeight, known := ruleWeights[rule]
+		if !known {
+			return loaded, nil, fmt.Errorf("%v: unknown rule %q, expected one of %v", fileName, rule, strings.Join(slices.Sorted(maps.Keys(ruleWeights)), ", "))
+		}
+		for i, weight := range ruleWeight {
+			if !on {
+				*weight = 0
+			} else if *weight == 0 {
+				*weight = *enabledRuleWeights[rule][i]
+			}
+		}
+	}
+
+	return loaded, loadedZones, nil
+}
+
+// Gives the zone holding a path and its factor: the deepest zone when they are nested
+func pathZone(path string) (string, float64, bool) {
+	found := ""
+	for zone := range zones {
+		if (path == zone || strings.HasPrefix(path, strings.TrimSuffix(zone, string(os.PathSeparator))+string(os.PathSeparator))) && len(zone) > len(found) {
+			found = zone
+		}
+	}
+	if found == "" {
+		return "", 1, false
+	}
+	return found, zones[found], true
+}
+
+// The weights of the rules turned on in the Rules section of a '--weights' file: the default ones, and a weight for
+// the rules off by default
+func enabledWeights() Weights {
+	enabled := defaultWeights()
+	enabled.UnusualName = 0.25
+	enabled.ConfusableName = 0.5
+	enabled.RecentCreation = 0.2
+	enabled.ExtensionlessExecutable = 0.25
+	enabled.CrowdedDir = 0.1
+	enabled.Untracked = 0.3
+	return enabled
+}
+
+// Gives the weights of each rule by its name, as used in the Rules section of a '--weights' file
+func (w *Weights) rules() map[string][]*float64 {
+	return map[string][]*float64{
+		"LargeFile":               {&w.LargeFile},
+		"RecentChange":            {&w.RecentChange},
+		"SensitiveName":           {&w.SensitiveName},
+		"Extension":               {&w.Extension},
+		"DirName":                 {&w.ShortDirName, &w.MediumDirName, &w.LongDirName},
+		"BrokenLink":              {&w.BrokenLink},
+		"UnusualName":             {&w.UnusualName},
+		"ConfusableName":          {&w.ConfusableName},
+		"RecentCreation":          {&w.RecentCreation},
+		"ExtensionlessExecutable": {&w.ExtensionlessExecutable},
+		"ManifestChange":          {&w.ManifestChange},
+		"CrowdedDir":              {&w.CrowdedDir},
+		"Untracked":               {&w.Untracked},
+	}
+}
+
+// A named configuration ('--preset'): the weights of the rules and arguments, which the command line can still override
+type Preset struct {
+	Weights Weights
+	Args    []string
+}
+
+// The presets, built on the default weights
+func presets() map[string]Preset {
+	secrets := defaultWeights()
+	secrets.SensitiveName = 1.0
+	secrets.LargeFile = 0
+	secrets.UnusualName = 0.25
+	secrets.ConfusableName = 0.5
+
+	largeFiles := defaultWeights()
+	largeFiles.LargeFile = 1.0
+	largeFiles.SensitiveName = 0.25
+	largeFiles.RecentChange = 0.05
+
+	recentActivity := defaultWeights()
+	recentActivity.RecentChange = 0.75
+	recentActivity.RecentCreation = 0.5
+	recentActivity.LargeFile = 0.1
+
+	return map[string]Preset{
+		// Small files (keys, .env) matter the most, and disguised names
+		"secrets": {Weights: secrets, Args: []string{noSizeFloorArg}},
+		// Only the files of a megabyte or more
+		"large-files": {Weights: largeFiles, Args: []string{sizeFloorArg, "1MB"}},
+		// What changed in the last month
+		"recent-activity": {Weights: recentActivity, Args: []string{sinceArg, "30d"}},
+	}
+}
+
+// Initializes the set of file names that usually hold secrets. Only exact matches of the base name count.
+func initSensitiveFileNames() map[string]bool {
+	return makeNameSet([]string{
+		"id_rsa", "id_dsa", "id_ecdsa", "id_ed25519",
+		".env", "credentials", ".npmrc", ".pypirc", ".netrc", ".pgpass",
+		"shadow", "passwd", ".htpasswd", ".git-credentials",
+	})
+}
+
+// Builds a set out of a list of names, ignoring blank ones
+func makeNameSet(names []string) map[string]bool {
+	nameSet := make(map[string]bool)
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			nameSet[name] = true
+		}
+	}
+	return nameSet
+}
+
+// Reads the extension risks from a config file, to be used instead of the built-in values.
+// The format is either "json" or "csv" (rows of extension,risk, with an optional header).
+// A json config is either an object of extension → risk, or an ExtensionConfig with categories.
+// When format is empty it is inferred from the file extension.
+func loadExtensionConfig(fileName string, format string) (ExtensionConfig, error) {
+	config := ExtensionConfig{
+		Risks:      make(map[string]float64),
+		Categories: make(map[string]float64),
+		Extensions: make(map[string]string),
+	}
+
+	if format == "" {
+		name := fileName
+		if parsed, errParse := url.Parse(fileName); errParse == nil && isConfigURL(fileName) {
+			name = parsed.Path
+		}
+		format = strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".")
+	}
+
+	file, errOpen := openConfig(fileName)
+	if errOpen != nil {
+		return config, errOpen
+	}
+	defer file.Close()
+
+	var errRead error
+
+	switch format {
+	case "json":
+		config, errRead = readExtensionJson(file)
+	case "csv":
+		config.Risks, errRead = readExtensionCsv(file)
+	default:
+		return config, fmt.Errorf("unknown extension config format %q, expected json or csv", format)
+	}
+
+	if errRead != nil {
+		return config, fmt.Errorf("%v: %w", fileName, errRead)
+	}
+
+	return config, nil
+}
+
+// Reads the config files of a served scan again, the requests started before keep the previous config
+func reloadConfig(scanArgs []string) error {
+	argValues := readCommandLineArgs(scanArgs)
+	base := defaultWeights()
+	if preset, known := presets()[argValues[presetArg]]; known {
+		base = preset.Weights
+	}
+	config, errConfig := loadConfigFiles(scanArgs, argValues, base)
+	if errConfig != nil {
+		return errConfig
+	}
+	servedConfig.Store(config)
+	return nil
+}
+
+// Reads the weights, the zones and the extension configs of the command line, the weights file overriding base.
+// '--deterministic' turns the rules on times off.
+func loadConfigFiles(args []string, argValues map[string]string, base Weights) (*configFiles, error) {
+	config := &configFiles{weights: base, extensionConfig: initExtensionConfig()}
+
+	if weightsFile, ok := argValues[weightsArg]; ok {
+		var errWeights error
+		config.weights, config.zones, errWeights = loadWeights(weightsFile, base)
+		if errWeights != nil {
+			return nil, fmt.Errorf("reading the weights: %w", errWeights)
+		}
+	}
+
+	// Scores depending on the clock can't be reproduced, nor trusted on fresh checkouts where every file has the same time
+	if argValues[deterministicArg] == "true" {
+		config.weights.RecentChange = 0
+		config.weights.RecentCreation = 0
+	}
+
+	// The first config replaces the built-in one, the next ones override it
+	for i, extConfigFile := range readRepeatedArg(args, extConfigArg) {
+		loadedConfig, errConfig := loadExtensionConfig(extConfigFile, argValues[extConfigFormatArg])
+		if errConfig != nil {
+			return nil, fmt.Errorf("reading the extension config: %w", errConfig)
+		}
+		if i == 0 {
+			config.extensionConfig = loadedConfig
+		} else {
+			mergeExtensionConfig(&config.extensionConfig, loadedConfig)
+		}
+	}
+	return config, nil
+}
+
+// Hides the secrets a config URL can hold, its password and its query (tokens...), to print it
+func redactLocation(location string) string {
+	parsed, errParse := url.Parse(location)
+	if errParse != nil || !isConfigURL(location) {
+		return location
+	}
+	if parsed.RawQuery != "" {
+		parsed.RawQuery = "redacted"
+	}
+	return parsed.Redacted()
+}
+
+// Tells if a config is given as an http(s) URL rather than a file
+func isConfigURL(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+// Opens a config file, or fetches it when it is an http(s) URL, for policies managed in one place.
+// A fetched config must be json, csv or plain text, not larger than remoteConfigMaxSize, and come within remoteConfigTimeout.
+func openConfig(location string) (io.ReadCloser, error) {
+	if !isConfigURL(location) {
+		return os.Open(location)
+	}
+
+	client := http.Client{Timeout: remoteConfigTimeout}
+	response, errGet := client.Get(location)
+	if errGet != nil {
+		return nil, errGet
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching %v: %v", location, response.Status)
+	}
+	contentType := response.Header.Get("Content-Type")
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType != "application/json" && mediaType != "text/csv" && mediaType != "text/plain" {
+		return nil, fmt.Errorf("fetching %v: unexpected content type %q, expected json, csv or plain text", location, contentType)
+	}
+
+	content, errRead := io.ReadAll(io.LimitReader(response.Body, remoteConfigMaxSize+1))
+	if errRead != nil {
+		return nil, fmt.Errorf("fetching %v: %w", location, errRead)
+	}
+	if len(content) > remoteConfigMaxSize {
+		return nil, fmt.Errorf("fetching %v: larger than %v bytes", location, remoteConfigMaxSize)
+	}
+	return io.NopCloser(bytes.NewReader(content)), nil
+}
+
+// Puts the extensions of a config in lower case, to match the extensions of the files when the case is ignored
+func lowerExtensionConfig(config *ExtensionConfig) {
+	risks := make(map[string]float64)
+	for extension, risk := range config.Risks {
+		risks[strings.ToLower(extension)] = risk
+	}
+	extensions := make(map[string]string)
+	for extension, category := range config.Extensions {
+		extensions[strings.ToLower(extension)] = category
+	}
+	config.Risks, config.Extensions = risks, extensions
+	for i, extension := range config.AlwaysMax {
+		config.AlwaysMax[i] = strings.ToLower(extension)
+	}
+}
+
+// Tells if a directory is on a file system ignoring the case of the names (the default on macOS and Windows), by
+// looking for it under its name with the case of its letters swapped. A name without letters can't tell, false then.
+func caseInsensitiveDir(dir string) bool {
+	absoluteDir, errAbs := filepath.Abs(windowsPath(dir))
+	if errAbs != nil {
+		return false
+	}
+	name := filepath.Base(absoluteDir)
+	swapped := strings.Map(func(char rune) rune {
+		if unicode.IsUpper(char) {
+			return unicode.ToLower(char)
+		}
+		return unicode.ToUpper(char)
+	}, name)
+	if swapped == name {
+		return false
+	}
+	info, errStat := os.Stat(absoluteDir)
+	if errStat != nil {
+		return false
+	}
+	swappedInfo, errSwapped := os.Stat(filepath.Join(filepath.Dir(absoluteDir), swapped))
+	return errSwapped == nil && os.SameFile(info, swappedInfo)
+}
+
+// Adds the risks, categories and extensions of an override to a config, replacing the ones it already has.
+// An extension moved to a category by the override loses its own risk from the config.
+func mergeExtensionConfig(config *ExtensionConfig, override ExtensionConfig) {
+	for extension, category := range override.Extensions {
+		config.Extensions[extension] = category
+		delete(config.Risks, extension)
+	}
+	for category, risk := range override.Categories {
+		config.Categories[category] = risk
+	}
+	for extension, risk := range override.Risks {
+		config.Risks[extension] = risk
+	}
+	config.AlwaysMax = append(config.AlwaysMax, override.AlwaysMax...)
+}
+
+// Reads a json object mapping extensions to risks, or an ExtensionConfig mapping extensions to categories
+func readExtensionJson(reader io.Reader) (ExtensionConfig, error) {
+	config := ExtensionConfig{
+		Risks:      make(map[string]float64),
+		Categories: make(map[string]float64),
+		Extensions: make(map[string]string),
+	}
+
+	content, errRead := io.ReadAll(reader)
+	if errRead != nil {
+		return config, errRead
+	}
+
+	// Try the categories first: only an ExtensionConfig decodes without unknown fields
+	var categorized ExtensionConfig
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.DisallowUnknownFields()
+	if decoder.Decode(&categorized) == nil {
+		for category, risk := range categorized.Categories {
+			config.Categories[category] = risk
+		}
+		for extension, category := range categorized.Extensions {
+			if _, known := config.Categories[category]; !known {
+				return config, fmt.Errorf("extension %v is in unknown category %q", extension, category)
+			}
+			config.Extensions[normalizeExtension(extension)] = category
+		}
+		for extension, risk := range categorized.Risks {
+			config.Risks[normalizeExtension(extension)] = risk
+		}
+		for _, extension := range categorized.AlwaysMax {
+			config.AlwaysMax = append(config.AlwaysMax, normalizeExtension(extension))
+		}
+		return config, nil
+	}
+
+	var raw map[string]float64
+	if errDecode := json.Unmarshal(content, &raw); errDecode != nil {
+		return config, errDecode
+	}
+	for extension, risk := range raw {
+		config.Risks[normalizeExtension(extension)] = risk
+	}
+
+	return config, nil
+}
+
+// Reads csv rows of "extension,risk". The first row is treated as a header if its risk is not a number.
+func readExtensionCsv(reader io.Reader) (map[string]float64, error) {
+	csvReader := csv.NewReader(reader)
+	csvReader.FieldsPerRecord = 2
+	csvReader.TrimLeadingSpace = true
+
+	extensionValues := make(map[string]float64)
+
+	for line := 1; ; line++ {
+		record, errRecord := csvReader.Read()
+		if errors.Is(errRecord, io.EOF) {
+			break
+		}
+		if errRecord != nil {
+			return nil, errRecord
+		}
+
+		risk, errParse := strconv.ParseFloat(strings.TrimSpace(record[1]), 64)
+		if errParse != nil {
+			if line == 1 {
+				// Header row, nothing to read
+				continue
+			}
+			return nil, fmt.Errorf("line %d: invalid risk %q", line, record[1])
+		}
+		// ParseFloat reads "NaN" and "Inf" too, which would poison every sum they are added to
+		if math.IsNaN(risk) || math.IsInf(risk, 0) {
+			return nil, fmt.Errorf("line %d: invalid risk %q", line, record[1])
+		}
+
+		extensionValues[normalizeExtension(record[0])] = risk
+	}
+
+	return extensionValues, nil
+}
+
+// Makes sure an extension from a config file looks like the output of filepath.Ext (".json")
+func normalizeExtension(extension string) string {
+	extension = strings.TrimSpace(extension)
+	if !strings.HasPrefix(extension, ".") {
+		extension = "." + extension
+	}
+	return extension
+}
+
+// Reads the command line arguments.
+// We need values for '--dir' and '--out', the others are optional. Doesn't matter the order, ignore other args.
+// Switches (see switchArgs) don't take a value and are set to "true" when present.
+// There is probably a better way of doing this in a library somewhere but I don't know enough Go to know about it...
+func readCommandLineArgs(args []string) map[string]string {
+	size := len(args)
+
+	var result map[string]string = make(map[string]string)
+
+	for i := 0; i < size; i++ {
+		if isArgIn(args[i], valueArgs) && i+1 < size {
+			result[args[i]] = args[i+1]
+			// Skip a step because we consumed the value already
+			i++
+		} else if isArgIn(args[i], switchArgs) {
+			result[args[i]] = "true"
+		}
+	}
+
+	return result
+}
+
+// Gives every value of an argument that can be repeated, in order. readCommandLineArgs only keeps the last one.
+func readRepeatedArg(args []string, name string) []string {
+	var values []string
+	for i := 0; i < len(args); i++ {
+		if isArgIn(args[i], valueArgs) && i+1 < len(args) {
+			if args[i] == name {
+				values = append(values, args[i+1])
+			}
+			i++
+		}
+	}
+	return values
+}
+
+// Gives the arguments without any occurrence of a value argument and its value
+func withoutArg(args []string, name string) []string {
+	var kept []string
+	for i := 0; i < len(args); i++ {
+		if args[i] == name && i+1 < len(args) {
+			i++
+			continue
+		}
+		kept = append(kept, args[i])
+	}
+	return kept
+}
+
+// Checks if an argument is part of a list of known arguments
+func isArgIn(arg string, knownArgs []string) bool {
+	for _, known := range knownArgs {
+		if arg == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Keeps the riskiest results added so far, up to a limit, so memory doesn't grow with the number of files.
+// It is a min-heap on the risk (see container/heap): the least risky result is the first one to be replaced.
+type topResults struct {
+	limit   int
+	added   int
+	entries []rankedResult
+}
+
+// A result and the order it was added in, so the kept results can be listed in that order
+type rankedResult struct {
+	result FileResult
+	order  int
+}
+
+func newTopResults(limit int) *topResults {
+	return &topResults{limit: limit}
+}
+
+func (top *topResults) Len() int {
+	return len(top.entries)
+}
+
+func (top *topResults) Less(i, j int) bool {
+	return lessRisky(top.entries[i].result, top.entries[j].result)
+}
+
+// Orders the results by risk. Among equal risks the larger path is the less risky one, so the same files are kept
+// whatever the walk order.
+func lessRisky(a FileResult, b FileResult) bool {
+	if a.Risk != b.Risk {
+		return a.Risk < b.Risk
+	}
+	return a.Path > b.Path
+}
+
+func (top *topResults) Swap(i, j int) {
+	top.entries[i], top.entries[j] = top.entries[j], top.entries[i]
+}
+
+func (top *topResults) Push(x any) {
+	top.entries = append(top.entries, x.(rankedResult))
+}
+
+func (top *topResults) Pop() any {
+	last := top.entries[len(top.entries)-1]
+	top.entries = top.entries[:len(top.entries)-1]
+	return last
+}
+
+// Adds a result, replacing the least risky one (see lessRisky) when the limit is reached and the new one is riskier
+func (top *topResults) add(result FileResult) {
+	ranked := rankedResult{result: result, order: top.added}
+	top.added++
+
+	if top.Len() < top.limit {
+		heap.Push(top, ranked)
+		return
+	}
+	if top.limit > 0 && lessRisky(top.entries[0].result, result) {
+		top.entries[0] = ranked
+		heap.Fix(top, 0)
+	}
+}
+
+// Lists the kept results in the order they were added
+func (top *topResults) list() []FileResult {
+	ordered := make([]rankedResult, len(top.entries))
+	copy(ordered, top.entries)
+	sort.Slice(ordered, func(i, j int) bool { return ordered[i].order < ordered[j].order })
+
+	results := make([]FileResult, len(ordered))
+	for i, ranked := range ordered {
+		results[i] = ranked.result
+	}
+	return results
+}
+
+// Keeps the riskiest results up to a limit like topResults, but can be shared by several goroutines
+type ResultCollector struct {
+	mutex sync.Mutex
+	top   *topResults
+}
+
+func NewResultCollector(limit int) *ResultCollector {
+	return &ResultCollector{top: newTopResults(limit)}
+}
+
+// Adds a result, replacing the least risky one when the limit is reached and the new one is riskier
+func (collector *ResultCollector) Add(result FileResult) {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	collector.top.add(result)
+}
+
+// Lists the kept results in the order they were added
+func (collector *ResultCollector) Results() []FileResult {
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	return collector.top.list()
+}
+
+// Makes sure the directory of the output file exists, creating it (and its parents) if mkdir is set
+func checkOutputDir(outFileName string, mkdir bool) error {
+	outDir := filepath.Dir(outFileName)
+
+	dirInfo, errStat := os.Stat(outDir)
+	if errStat == nil {
+		if !dirInfo.IsDir() {
+			return fmt.Errorf("%v is not a directory", outDir)
+		}
+		return nil
+	}
+
+	if !errors.Is(errStat, fs.ErrNotExist) {
+		return errStat
+	}
+
+	if !mkdir {
+		return fmt.Errorf("output directory %v does not exist, use '%v' to create it", outDir, mkdirOutArg)
+	}
+
+	return os.MkdirAll(outDir, os.ModePerm)
+}
+
+// Reads a report previously written by writeJsonToFile. A missing file is an empty report.
+func readReport(fileName string) (DirResult, error) {
+	var report DirResult
+
+	file, errOpen := os.Open(fileName)
+	if errors.Is(errOpen, fs.ErrNotExist) {
+		return report, nil
+	}
+	if errOpen != nil {
+		return report, errOpen
+	}
+	defer file.Close()
+
+	if errDecode := json.NewDecoder(file).Decode(&report); errDecode != nil && !errors.Is(errDecode, io.EOF) {
+		return report, fmt.Errorf("%v: %w", fileName, errDecode)
+	}
+
+	return report, nil
+}
+
+// The risks of the files of a previous report, to find the ones that got riskier ('--regressions-since')
+type baseline struct {
+	risks map[string]float64
+	// Smallest increase of risk reported ('--min-increase')
+	minIncrease float64
+}
+
+// Reads the baseline report. Unlike for '--append', the report must exist.
+func readBaseline(fileName string, minIncrease float64) (*baseline, error) {
+	if _, errStat := os.Stat(fileName); errStat != nil {
+		return nil, errStat
+	}
+	report, errReport := readReport(fileName)
+	if errReport != nil {
+		return nil, errReport
+	}
+	previous := &baseline{risks: make(map[string]float64), minIncrease: minIncrease}
+	for _, result := range report.allResults() {
+		previous.risks[result.Path] = result.Risk
+	}
+	return previous, nil
+}
+
+// Checks if a file got riskier than in the baseline by more than the minimum increase, and notes by how much.
+// The files missing from the baseline have no previous risk to compare with, they never regressed.
+func (previous *baseline) regressed(result *FileResult) bool {
+	previousRisk, known := previous.risks[result.Path]
+	if !known || result.Risk-previousRisk <= 0 || result.Risk-previousRisk < previous.minIncrease {
+		return false
+	}
+	result.Notes = append(result.Notes, fmt.Sprintf("risk increased from %v", previousRisk))
+	return true
+}
+
+// Merges two lists of results, keeping one result per path with the highest risk.
+// The order of the first appearance of each path is kept.
+func mergeResults(previous []FileResult, current []FileResult) []FileResult {
+	all := append(append([]FileResult{}, previous...), current...)
+	return dedupeResults(all, "path")
+}
+
+// Keeps a single result, the riskiest one, for all the results sharing the same path or the same base name (dedupeBy).
+// The order of the first appearance of each path or name is kept.
+// When names are collapsed, the kept result notes how many files had that name.
+func dedupeResults(results []FileResult, dedupeBy string) []FileResult {
+	var deduped []FileResult
+	indexByKey := make(map[string]int)
+	countByKey := make(map[string]int)
+
+	for _, result := range results {
+		key := result.Path
+		switch dedupeBy {
+		case "basename":
+			key = filepath.Base(result.Path)
+		case "risk":
+			key = riskKey(result)
+		}
+		countByKey[key]++
+
+		index, seen := indexByKey[key]
+		if !seen {
+			indexByKey[key] = len(deduped)
+			deduped = append(deduped, result)
+		} else if result.Risk > deduped[index].Risk {
+			deduped[index] = result
+		}
+	}
+
+	if dedupeBy == "basename" {
+		for i, result := range deduped {
+			name := filepath.Base(result.Path)
+			if count := countByKey[name]; count > 1 {
+				deduped[i].Notes = append(deduped[i].Notes, fmt.Sprintf("riskiest of %v files named %v", count, name))
+			}
+		}
+	}
+	if dedupeBy == "risk" {
+		for i, result := range deduped {
+			if count := countByKey[riskKey(result)]; count > 1 {
+				deduped[i].Notes = append(deduped[i].Notes, fmt.Sprintf("one of %v files of this directory with this risk", count))
+			}
+		}
+	}
+
+	return deduped
+}
+
+// The key of the results sharing a directory and a risk, collapsed by '--dedupe-by risk'
+func riskKey(result FileResult) string {
+	return filepath.Dir(result.Path) + "|" + strconv.FormatFloat(result.Risk, 'g', -1, 64)
+}
+
+// The age buckets of the summary, from the most recent one
+var ageBuckets = []struct {
+	Label  string
+	MaxAge time.Duration
+}{
+	{"day", 24 * time.Hour},
+	{"week", hoursInWeek * time.Hour},
+	{"month", 30 * 24 * time.Hour},
+	{"year", 365 * 24 * time.Hour},
+}
+
+// Creates an empty summary, with a histogram of the risks when histogramBins isn't 0
+func newSummary(histogramBins int, now time.Time) *Summary {
+	summary := &Summary{
+		Bands:      make(map[string]int),
+		Extensions: make(map[string]*ExtensionStats),
+		Ages:       make(map[string]int),
+		now:        now,
+		failOver:   -1,
+	}
+	if histogramBins > 0 {
+		summary.Histogram = make([]int, histogramBins)
+	}
+
+	// Every band is listed, even without files
+	for _, band := range bands {
+		summary.Bands[band.Label] = 0
+	}
+	for _, bucket := range ageBuckets {
+		summary.Ages[bucket.Label] = 0
+	}
+	summary.Ages["older"] = 0
+	return summary
+}
+
+// Gives the label of the age bucket of a modification time
+func (summary *Summary) ageBucket(modTime time.Time) string {
+	age := summary.now.Sub(modTime)
+	for _, bucket := range ageBuckets {
+		if age <= bucket.MaxAge {
+			return bucket.Label
+		}
+	}
+	return "older"
+}
+
+// Counts a scored file in the summary
+func (summary *Summary) add(result FileResult) {
+	summary.ScoredFiles++
+	summary.totalRisk += result.Risk
+	if result.Risk > summary.MaxRisk {
+		summary.MaxRisk = result.Risk
+	}
+	summary.Bands[riskBand(result.Risk)]++
+	summary.Ages[summary.ageBucket(result.ModTime)]++
+	if summary.failOver >= 0 && result.Risk > summary.failOver {
+		summary.riskyFiles++
+	}
+
+	if bins := len(summary.Histogram); bins > 0 {
+		bin := int((result.Risk - minRisk) / (maxRisk - minRisk) * float64(bins))
+		// The max risk belongs to the last bin, and out of range risks to the closest one
+		bin = max(0, min(bin, bins-1))
+		summary.Histogram[bin]++
+	}
+
+	extension := strings.ToLower(filepath.Ext(result.Path))
+	if extension == "" {
+		extension = "(none)"
+	}
+	stats, ok := summary.Extensions[extension]
+	if !ok {
+		stats = &ExtensionStats{}
+		summary.Extensions[extension] = stats
+	}
+	stats.Files++
+	stats.totalRisk += result.Risk
+	if result.Risk > stats.MaxRisk {
+		stats.MaxRisk = result.Risk
+	}
+}
+
+// Computes the averages once every file has been added
+func (summary *Summary) finish() {
+	if summary.ScoredFiles > 0 {
+		summary.AverageRisk = summary.totalRisk / float64(summary.ScoredFiles)
+	}
+	for _, stats := range summary.Extensions {
+		stats.AverageRisk = stats.totalRisk / float64(stats.Files)
+	}
+}
+
+// Gives the label of the band of a risk. Risks below the first band belong to it.
+func riskBand(risk float64) string {
+	label := bands[0].Label
+	for _, band := range bands {
+		if risk >= band.Min {
+			label = band.Label
+		}
+	}
+	return label
+}
+
+// ANSI colors of the bands: the lowest band is green, the highest is red and the ones in between are yellow
+const (
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorRed    = "\x1b[31m"
+	colorReset  = "\x1b[0m"
+)
+
+// Wraps a text in the color of the band, when colors are enabled
+func colorBand(text string, label string, colorize bool) string {
+	if !colorize {
+		return text
+	}
+
+	color := colorYellow
+	if label == bands[0].Label {
+		color = colorGreen
+	} else if label == bands[len(bands)-1].Label {
+		color = colorRed
+	}
+	return color + text + colorReset
+}
+
+// Decides if the summary is colored from the '--color' value: "always", "never" or "auto" (only when stderr is a terminal)
+func useColor(mode string, stderr io.Writer) (bool, error) {
+	switch mode {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "", "auto":
+		file, isFile := stderr.(*os.File)
+		return isFile && isTerminal(file), nil
+	}
+	return false, fmt.Errorf("unknown color mode %q, expected auto, always or never", mode)
+}
+
+// Checks if a file is a terminal (a character device), good enough to decide on colors
+func isTerminal(file *os.File) bool {
+	info, errStat := file.Stat()
+	return errStat == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Writes a short human readable summary of the results: the number of files in each band and the riskiest files
+func writeSummary(writer io.Writer, data DirResult, colorize bool) {
+	scanned := data.Dir
+	if len(data.Roots) > 0 {
+		scanned = strings.Join(data.Roots, ", ")
+	}
+	results := data.allResults()
+	fmt.Fprintf(writer, "Scan of %v: %v files reported\n", scanned, len(results))
+
+	// The bands of every scored file when we have them, otherwise the ones of the reported files
+	bandCounts := make(map[string]int)
+	if data.Summary != nil {
+		fmt.Fprintf(writer, "  %v files scored, average risk %.2f\n", data.Summary.ScoredFiles, data.Summary.AverageRisk)
+		bandCounts = data.Summary.Bands
+	} else {
+		for _, result := range results {
+			bandCounts[riskBand(result.Risk)]++
+		}
+	}
+	// From the highest band to the lowest
+	for i := len(bands) - 1; i >= 0; i-- {
+		label := bands[i].Label
+		fmt.Fprintf(writer, "  %v: %v\n", colorBand(label, label, colorize), bandCounts[label])
+	}
+
+	sorted := make([]FileResult, len(results))
+	copy(sorted, results)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Risk > sorted[j].Risk })
+
+	for i, result := range sorted {
+		if i == summaryFiles {
+			break
+		}
+		risk := fmt.Sprintf("%.2f", result.Risk)
+		fmt.Fprintf(writer, "  %v  %v\n", colorBand(risk, riskBand(result.Risk), colorize), result.Path)
+	}
+}
+
+// Writes a few lines about the scan for whoever is watching the terminal: the number of files, the riskiest ones and
+// where the report is. writeSummary tells more.
+func writeEndSummary(writer io.Writer, data DirResult, outFileName string, colorize bool) {
+	results := data.allResults()
+	scored := len(results)
+	if data.Summary != nil {
+		scored = data.Summary.ScoredFiles
+	}
+	fmt.Fprintf(writer, "%v files scored, %v reported in %v\n", scored, len(results), outFileName)
+
+	sorted := append([]FileResult{}, results...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Risk > sorted[j].Risk })
+	for i, result := range sorted {
+		if i == endSummaryFiles {
+			break
+		}
+		risk := fmt.Sprintf("%.2f", result.Risk)
+		fmt.Fprintf(writer, "  %v  %v\n", colorBand(risk, riskBand(result.Risk), colorize), result.Path)
+	}
+}
+
+// Reads the '--since' value: either a date (RFC3339) or a duration before now ("7d", "12h")
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if since, errTime := time.Parse(time.RFC3339, value); errTime == nil {
+		return since, nil
+	}
+
+	duration, errDuration := parseDuration(value)
+	if errDuration != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q, expected a RFC3339 date or a duration like 7d", value)
+	}
+	return now.Add(-duration), nil
+}
+
+// Parses a duration, with days ("7d") and weeks ("2w") on top of what time.ParseDuration knows
+func parseDuration(value string) (time.Duration, error) {
+	units := map[string]time.Duration{"d": 24 * time.Hour, "w": hoursInWeek * time.Hour}
+	for suffix, unit := range units {
+		if number, found := strings.CutSuffix(value, suffix); found {
+			count, errParse := strconv.ParseFloat(number, 64)
+			if errParse != nil {
+				return 0, fmt.Errorf("invalid duration %q", value)
+			}
+			return time.Duration(count * float64(unit)), nil
+		}
+	}
+	return time.ParseDuration(value)
+}
+
+// The units of the sizes, decimal (KB) or binary (KiB)
+var sizeUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"kb":  1000,
+	"mb":  1000 * 1000,
+	"gb":  1000 * 1000 * 1000,
+	"tb":  1000 * 1000 * 1000 * 1000,
+	"kib": 1 << 10,
+	"mib": 1 << 20,
+	"gib": 1 << 30,
+	"tib": 1 << 40,
+}
+
+// Reads a size in bytes, either a number of bytes or a number followed by a unit ("10MB", "1.5GiB")
+func parseSize(value string) (int64, error) {
+	trimmed := strings.TrimSpace(value)
+	unitStart := strings.IndexFunc(trimmed, func(char rune) bool {
+		return !unicode.IsDigit(char) && char != '.'
+	})
+	if unitStart < 0 {
+		unitStart = len(trimmed)
+	}
+	unit, known := sizeUnits[strings.ToLower(strings.TrimSpace(trimmed[unitStart:]))]
+	number, errParse := strconv.ParseFloat(trimmed[:unitStart], 64)
+	if !known || errParse != nil || number < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return int64(number * float64(unit)), nil
+}
+
+// Reads the '--indent' value: a number of spaces, "tab", or "compact" for no indentation at all. Defaults to four spaces.
+func parseIndent(value string) (string, error) {
+	switch value {
+	case "":
+		return "    ", nil
+	case "tab":
+		return "\t", nil
+	case "compact":
+		return "", nil
+	}
+
+	spaces, errAtoi := strconv.Atoi(value)
+	if errAtoi != nil || spaces < 0 {
+		return "", fmt.Errorf("invalid indent %q, expected a number of spaces, tab or compact", value)
+	}
+	return strings.Repeat(" ", spaces), nil
+}
+
+// Starts writing a CPU profile (see runtime/pprof) to a file. The returned function stops it.
+func startCPUProfile(fileName string) (func(), error) {
+	profileFile, errCreate := os.Create(fileName)
+	if errCreate != nil {
+		return nil, errCreate
+	}
+
+	if errStart := pprof.StartCPUProfile(profileFile); errStart != nil {
+		profileFile.Close()
+		return nil, errStart
+	}
+
+	return func() {
+		pprof.StopCPUProfile()
+		profileFile.Close()
+	}, nil
+}
+
+// Writes a heap profile (see runtime/pprof) to a file
+func writeMemProfile(fileName string) error {
+	profileFile, errCreate := os.Create(fileName)
+	if errCreate != nil {
+		return errCreate
+	}
+	defer profileFile.Close()
+
+	// Get up-to-date statistics
+	runtime.GC()
+	return pprof.WriteHeapProfile(profileFile)
+}
+
+// Gives a risk with the '--round' decimals, as it is written
+func roundRisk(risk float64) float64 {
+	if roundDigits < 0 {
+		return risk
+	}
+	scale := math.Pow(10, float64(roundDigits))
+	return math.Round(risk*scale) / scale
+}
+
+// Writes the risks with the '--round' decimals, the other fields as usual
+func (summary Summary) MarshalJSON() ([]byte, error) {
+	// Without the methods of Summary, so this one isn't called again
+	type plainSummary Summary
+	summary.AverageRisk, summary.MaxRisk = roundRisk(summary.AverageRisk), roundRisk(summary.MaxRisk)
+	return json.Marshal(plainSummary(summary))
+}
+
+// Writes the risks with the '--round' decimals, the other fields as usual
+func (stats ExtensionStats) MarshalJSON() ([]byte, error) {
+	type plainStats ExtensionStats
+	stats.AverageRisk, stats.MaxRisk = roundRisk(stats.AverageRisk), roundRisk(stats.MaxRisk)
+	return json.Marshal(plainStats(stats))
+}
+
+// Writes ModTime in the '--time-format' and Risk with the '--round' decimals, the other fields as usual
+func (result FileResult) MarshalJSON() ([]byte, error) {
+	// Without the methods of FileResult, so this one isn't called again
+	type plainResult FileResult
+	result.Risk = roundRisk(result.Risk)
+	result.Path, result.PathEncoding = encodePath(result.Path)
+	switch timeFormat {
+	case "rfc3339":
+		return json.Marshal(plainResult(result))
+	case "unix":
+		return json.Marshal(struct {
+			plainResult
+			ModTime int64
+		}{plainResult(result), result.ModTime.Unix()})
+	}
+	return json.Marshal(struct {
+		plainResult
+		ModTime string
+	}{plainResult(result), result.ModTime.Format(timeFormat)})
+}
+
+// Encodes a path that isn't valid UTF-8 with pathEncoding, so no byte is lost in the json output. Gives the encoded
+// path and the encoding, none for a valid path or when the invalid bytes are replaced.
+func encodePath(path string) (string, string) {
+	if utf8.ValidString(path) || pathEncoding == "replace" {
+		return path, ""
+	}
+	if pathEncoding == "base64" {
+		return base64.StdEncoding.EncodeToString([]byte(path)), pathEncoding
+	}
+
+	// The backslashes are escaped too, so a \xNN in the name itself can't be taken for an escaped byte
+	var escaped strings.Builder
+	for len(path) > 0 {
+		r, size := utf8.DecodeRuneInString(path)
+		if r == utf8.RuneError && size == 1 {
+			fmt.Fprintf(&escaped, "\\x%02x", path[0])
+		} else if r == '\\' {
+			escaped.WriteString(`\\`)
+		} else {
+			escaped.WriteString(path[:size])
+		}
+		path = path[size:]
+	}
+	return escaped.String(), pathEncoding
+}
+
+// Gives back a path encoded by encodePath with the encoding, which a report read again ('--append', '--rescore') or a
+// manifest tells along with the path
+func decodePath(path string, encoding string) (string, error) {
+	switch encoding {
+	case "":
+		return path, nil
+	case "base64":
+		decoded, errDecode := base64.StdEncoding.DecodeString(path)
+		if errDecode != nil {
+			return "", fmt.Errorf("invalid base64 path %q: %w", path, errDecode)
+		}
+		return string(decoded), nil
+	case "escape":
+		var decoded strings.Builder
+		for i := 0; i < len(path); i++ {
+			switch {
+			case path[i] != '\\':
+				decoded.WriteByte(path[i])
+			case strings.HasPrefix(path[i:], `\\`):
+				decoded.WriteByte('\\')
+				i++
+			case strings.HasPrefix(path[i:], `\x`) && i+4 <= len(path):
+				value, errHex := hex.DecodeString(path[i+2 : i+4])
+				if errHex != nil {
+					return "", fmt.Errorf("invalid escaped path %q: %w", path, errHex)
+				}
+				decoded.Write(value)
+				i += 3
+			default:
+				return "", fmt.Errorf("invalid escaped path %q", path)
+			}
+		}
+		return decoded.String(), nil
+	}
+	return "", fmt.Errorf("unknown path encoding %q", encoding)
+}
+
+// Encodes Path with '--output-encoding', like the one of FileResult
+func (warning Warning) MarshalJSON() ([]byte, error) {
+	type plainWarning Warning
+	warning.Path, warning.PathEncoding = encodePath(warning.Path)
+	return json.Marshal(plainWarning(warning))
+}
+
+// Decodes Path when it was encoded
+func (warning *Warning) UnmarshalJSON(data []byte) error {
+	type plainWarning Warning
+	if errDecode := json.Unmarshal(data, (*plainWarning)(warning)); errDecode != nil {
+		return errDecode
+	}
+	return decodePathField(&warning.Path, &warning.PathEncoding)
+}
+
+// Encodes Path with '--output-encoding', like the one of FileResult
+func (violation Violation) MarshalJSON() ([]byte, error) {
+	type plainViolation Violation
+	violation.Path, violation.PathEncoding = encodePath(violation.Path)
+	return json.Marshal(plainViolation(violation))
+}
+
+// Decodes Path when it was encoded
+func (violation *Violation) UnmarshalJSON(data []byte) error {
+	type plainViolation Violation
+	if errDecode := json.Unmarshal(data, (*plainViolation)(violation)); errDecode != nil {
+		return errDecode
+	}
+	return decodePathField(&violation.Path, &violation.PathEncoding)
+}
+
+// Encodes Path with '--output-encoding', like the one of FileResult. The entries of an archive have the bytes of their
+// names in the archive, which can be in any charset.
+func (entry ArchiveEntry) MarshalJSON() ([]byte, error) {
+	type plainEntry ArchiveEntry
+	entry.Path, entry.PathEncoding = encodePath(entry.Path)
+	return json.Marshal(plainEntry(entry))
+}
+
+// Decodes Path when it was encoded
+func (entry *ArchiveEntry) UnmarshalJSON(data []byte) error {
+	type plainEntry ArchiveEntry
+	if errDecode := json.Unmarshal(data, (*plainEntry)(entry)); errDecode != nil {
+		return errDecode
+	}
+	return decodePathField(&entry.Path, &entry.PathEncoding)
+}
+
+// Encodes Path with '--output-encoding', so '--previous-manifest' finds the files again
+func (entry ManifestEntry) MarshalJSON() ([]byte, error) {
+	type plainEntry ManifestEntry
+	entry.Path, entry.PathEncoding = encodePath(entry.Path)
+	return json.Marshal(plainEntry(entry))
+}
+
+// Decodes Path when it was encoded
+func (entry *ManifestEntry) UnmarshalJSON(data []byte) error {
+	type plainEntry ManifestEntry
+	if errDecode := json.Unmarshal(data, (*plainEntry)(entry)); errDecode != nil {
+		return errDecode
+	}
+	return decodePathField(&entry.Path, &entry.PathEncoding)
+}
+
+// Encodes Dir, the Roots and the keys of RootResults with '--output-encoding', like the paths of the results
+func (result DirResult) MarshalJSON() ([]byte, error) {
+	type plainDirResult DirResult
+	result.Dir, result.DirEncoding = encodePath(result.Dir)
+
+	roots, encodings := make([]string, len(result.Roots)), make([]string, len(result.Roots))
+	result.RootEncodings = nil
+	for i, root := range result.Roots {
+		roots[i], encodings[i] = encodePath(root)
+		if encodings[i] != "" {
+			result.RootEncodings = encodings
+		}
+	}
+	result.Roots = roots
+
+	if result.RootResults != nil {
+		rootResults := make(map[string][]FileResult, len(result.RootResults))
+		for root, results := range result.RootResults {
+			encoded, _ := encodePath(root)
+			rootResults[encoded] = results
+		}
+		result.RootResults = rootResults
+	}
+	return json.Marshal(plainDirResult(result))
+}
+
+// Decodes Dir, the Roots and the keys of RootResults when they were encoded
+func (result *DirResult) UnmarshalJSON(data []byte) error {
+	type plainDirResult DirResult
+	if errDecode := json.Unmarshal(data, (*plainDirResult)(result)); errDecode != nil {
+		return errDecode
+	}
+	// The keys of RootResults are encoded like the root they are, Dir when there is a single one
+	decodedRoots := make(map[string]string)
+	encodedDir := result.Dir
+	if errDir := decodePathField(&result.Dir, &result.DirEncoding); errDir != nil {
+		return errDir
+	}
+	decodedRoots[encodedDir] = result.Dir
+	if result.RootEncodings != nil && len(result.RootEncodings) != len(result.Roots) {
+		return fmt.Errorf("%v encodings for %v roots", len(result.RootEncodings), len(result.Roots))
+	}
+	for i, root := range result.Roots {
+		var encoding string
+		if result.RootEncodings != nil {
+			encoding = result.RootEncodings[i]
+		}
+		decoded, errRoot := decodePath(root, encoding)
+		if errRoot != nil {
+			return errRoot
+		}
+		result.Roots[i], decodedRoots[root] = decoded, decoded
+	}
+	result.RootEncodings = nil
+
+	if result.RootResults != nil {
+		rootResults := make(map[string][]FileResult, len(result.RootResults))
+		for root, results := range result.RootResults {
+			if decoded, known := decodedRoots[root]; known {
+				root = decoded
+			}
+			rootResults[root] = results
+		}
+		result.RootResults = rootResults
+	}
+	return nil
+}
+
+// Decodes a path field of a json record with the encoding field next to it, which is then emptied
+func decodePathField(path *string, encoding *string) error {
+	decoded, errDecode := decodePath(*path, *encoding)
+	if errDecode != nil {
+		return errDecode
+	}
+	*path, *encoding = decoded, ""
+	return nil
+}
+
+// Reads back a result written with any '--time-format': the modification time can be an RFC 3339 string, epoch
+// seconds, or a string with the layout of timeFormat
+func (result *FileResult) UnmarshalJSON(data []byte) error {
+	// Without the methods of FileResult, so this one isn't called again
+	type plainResult FileResult
+	var read struct {
+		plainResult
+		ModTime json.RawMessage
+	}
+	if errDecode := json.Unmarshal(data, &read); errDecode != nil {
+		return errDecode
+	}
+	*result = FileResult(read.plainResult)
+	if errPath := decodePathField(&result.Path, &result.PathEncoding); errPath != nil {
+		return errPath
+	}
+
+	modTime := strings.TrimSpace(string(read.ModTime))
+	if modTime == "" || modTime == "null" {
+		return nil
+	}
+	var epoch int64
+	if errEpoch := json.Unmarshal(read.ModTime, &epoch); errEpoch == nil {
+		result.ModTime = time.Unix(epoch, 0)
+		return nil
+	}
+	var text string
+	if errText := json.Unmarshal(read.ModTime, &text); errText != nil {
+		return fmt.Errorf("%v: invalid ModTime %v", result.Path, modTime)
+	}
+	if parsed, errParse := time.Parse(time.RFC3339Nano, text); errParse == nil {
+		result.ModTime = parsed
+		return nil
+	}
+	if timeFormat != "rfc3339" && timeFormat != "unix" {
+		if parsed, errParse := time.Parse(timeFormat, text); errParse == nil {
+			result.ModTime = parsed
+			return nil
+		}
+	}
+	return fmt.Errorf("%v: ModTime %q is neither RFC 3339 nor in the '%v' layout", result.Path, text, timeFormatArg)
+}
+
+// Removes the \\?\ prefix of a Windows extended-length path (\\?\C:\dir, \\?\UNC\server\share), which the path/filepath
+// functions don't understand. The os package adds it back itself to the long absolute paths, so deep trees and
+// network shares are still read. Other systems keep the path as it is.
+func windowsPath(path string) string {
+	if runtime.GOOS != "windows" {
+		return path
+	}
+	if rest, found := strings.CutPrefix(path, `\\?\UNC\`); found {
+		return `\\` + rest
+	}
+	return strings.TrimPrefix(path, `\\?\`)
+}
+
+// Makes the paths of the results relative to the base directory, when given. Paths out of it are left absolute.
+func relativePaths(results []FileResult, base string) {
+	if base == "" {
+		return
+	}
+	for i := range results {
+		relative, errRel := filepath.Rel(base, results[i].Path)
+		if errRel != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(os.PathSeparator)) {
+			continue
+		}
+		results[i].Path = relative
+	}
+}
+
+// Writes the results of a single directory as one line of the stream. Directories without results are skipped.
+func writeDirRecord(state *scanState, dir string, results []FileResult) {
+	if len(results) == 0 {
+		return
+	}
+	relativePaths(results, state.options.RelativeTo)
+	if errEncode := state.stream.Encode(DirResult{Dir: dir, Results: results}); errEncode != nil {
+		state.warn(dir, "writing the results of "+dir, errEncode)
+	}
+}
+
+// Writes the results of a directory to their own file in the '--output-dir', named by dirFileName
+func writeDirFile(state *scanState, dir string, results []FileResult) {
+	if len(results) == 0 {
+		return
+	}
+	relativePaths(results, state.options.RelativeTo)
+	file, errCreate := os.Create(filepath.Join(state.outputDir, dirFileName(dir)))
+	if errCreate != nil {
+		state.warn(dir, "writing the results of "+dir, errCreate)
+		return
+	}
+	defer file.Close()
+	if errEncode := json.NewEncoder(file).Encode(DirResult{Dir: dir, Results: results}); errEncode != nil {
+		state.warn(dir, "writing the results of "+dir, errEncode)
+	}
+}
+
+// Names the file of the results of a directory after its path, the separators replaced by '_' ("/home/me/docs" →
+// "home_me_docs.json"). The '_', '%' and the drive colon of the names are percent-encoded first, so two directories
+// never share a file ("/x/a/b_c" → "x_a_b%5Fc.json", "/x/a_b/c" → "x_a%5Fb_c.json"). A name longer than
+// maxFileNameLength is cut and ends with "%~" and a hash of the whole path, which the encoding never gives.
+func dirFileName(dir string) string {
+	name := strings.NewReplacer("%", "%25", "_", "%5F", ":", "%3A", "/", "_", "\\", "_").Replace(strings.TrimLeft(dir, "/\\"))
+	if name == "" {
+		name = "_"
+	}
+	if len(name)+len(".json") > maxFileNameLength {
+		suffix := fmt.Sprintf("%%~%x", sha256.Sum256([]byte(dir)))[:18]
+		cut := maxFileNameLength - len(".json") - len(suffix)
+		// Not in the middle of a character
+		for cut > 0 && !utf8.RuneStart(name[cut]) {
+			cut--
+		}
+		name = name[:cut] + suffix
+	}
+	return name + ".json"
+}
+
+// Moves the results of a report to the RootResults of the scanned directory they belong to, the deepest one when roots
+// are nested. Relative paths ('--relative-to') are resolved against relativeTo. The results out of every root are left in Results.
+func partitionByRoot(report *DirResult, relativeTo string) {
+	roots := report.Roots
+	if report.Dir != "" {
+		roots = []string{report.Dir}
+	}
+
+	report.RootResults = make(map[string][]FileResult)
+	for _, root := range roots {
+		report.RootResults[root] = []FileResult{}
+	}
+	outOfRoots := []FileResult{}
+	for _, result := range report.Results {
+		path := result.Path
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(relativeTo, path)
+		}
+		owner := ""
+		for _, root := range roots {
+			if (path == root || strings.HasPrefix(path, root+string(os.PathSeparator))) && len(root) > len(owner) {
+				owner = root
+			}
+		}
+		if owner == "" {
+			outOfRoots = append(outOfRoots, result)
+		} else {
+			report.RootResults[owner] = append(report.RootResults[owner], result)
+		}
+	}
+	report.Results = outOfRoots
+}
+
+// Lists the results of a report, including the ones partitioned by root, in the order of the roots
+func (report DirResult) allResults() []FileResult {
+	roots := make([]string, 0, len(report.RootResults))
+	for root := range report.RootResults {
+		roots = append(roots, root)
+	}
+	sort.Strings(roots)
+
+	results := append([]FileResult{}, report.Results...)
+	for _, root := range roots {
+		results = append(results, report.RootResults[root]...)
+	}
+	return results
+}
+
+// Writes results as the items of a json array as soon as they come, so the output stays a single json value
+// without keeping the results in memory
+type arrayWriter struct {
+	writer  io.Writer
+	written int
+}
+
+// Writes a result, after the opening bracket or a comma
+func (array *arrayWriter) write(result FileResult) error {
+	encoded, errEncode := json.Marshal(result)
+	if errEncode != nil {
+		return errEncode
+	}
+	separator := ",\n"
+	if array.written == 0 {
+		separator = "[\n"
+	}
+	if _, errWrite := io.WriteString(array.writer, separator); errWrite != nil {
+		return errWrite
+	}
+	array.written++
+	_, errWrite := array.writer.Write(encoded)
+	return errWrite
+}
+
+// Ends the array, which is empty if nothing was written
+func (array *arrayWriter) close() error {
+	end := "\n]\n"
+	if array.written == 0 {
+		end = "[]\n"
+	}
+	_, errWrite := io.WriteString(array.writer, end)
+	return errWrite
+}
+
+// Writes each result with the '--output-template', one per line, its risk with the '--round' decimals
+func writeTemplate(writer io.Writer, outputTemplate *template.Template, results []FileResult) error {
+	for _, result := range results {
+		result.Risk = roundRisk(result.Risk)
+		if errExecute := outputTemplate.Execute(writer, result); errExecute != nil {
+			return errExecute
+		}
+		if _, errWrite := io.WriteString(writer, "\n"); errWrite != nil {
+			return errWrite
+		}
+	}
+	return nil
+}
+
+// Writes the histogram of the summary as csv rows of min,max,files, to be plotted
+func writeHistogram(fileName string, summary *Summary) error {
+	histogramFile, errCreate := os.Create(fileName)
+	if errCreate != nil {
+		return errCreate
+	}
+	defer histogramFile.Close()
+
+	csvWriter := csv.NewWriter(histogramFile)
+	csvWriter.Write([]string{"min", "max", "files"})
+
+	width := (maxRisk - minRisk) / float64(len(summary.Histogram))
+	for i, files := range summary.Histogram {
+		binMin := minRisk + float64(i)*width
+		csvWriter.Write([]string{
+			strconv.FormatFloat(binMin, 'f', -1, 64),
+			strconv.FormatFloat(binMin+width, 'f', -1, 64),
+			strconv.Itoa(files),
+		})
+	}
+
+	csvWriter.Flush()
+	return csvWriter.Error()
+}
+
+// A file a report or a cache is written to. A regular file, or a missing one, is written through a temporary file
+// next to it, which replaces it once complete, so a failed scan leaves the previous one in place. The other files
+// (/dev/null, named pipes) and the streamed reports, meant to be read while they are written, are written directly.
+type outputFile struct {
+	*os.File
+	// The file replaced in the end, its symlinks resolved
+	name      string
+	temporary bool
+}
+
+func createOutput(name string, streamed bool) (*outputFile, error) {
+	if realName, errReal := filepath.EvalSymlinks(name); errReal == nil {
+		name = realName
+	}
+	if info, errStat := os.Stat(name); streamed || (errStat == nil && !info.Mode().IsRegular()) {
+		file, errOpen := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		if errOpen != nil {
+			return nil, errOpen
+		}
+		return &outputFile{File: file, name: name}, nil
+	}
+	file, errCreate := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".*.tmp")
+	if errCreate != nil {
+		return nil, errCreate
+	}
+	return &outputFile{File: file, name: name, temporary: true}, nil
+}
+
+// Closes a complete output, the temporary file replacing the output file with the permissions of the file it
+// replaces if any
+func (output *outputFile) commit() error {
+	if !output.temporary {
+		return output.Close()
+	}
+	mode := fs.FileMode(0644)
+	if info, errStat := os.Stat(output.name); errStat == nil {
+		mode = info.Mode().Perm()
+	}
+	if errChmod := output.Chmod(mode); errChmod != nil {
+		return errChmod
+	}
+	if errClose := output.Close(); errClose != nil {
+		return errClose
+	}
+	return os.Rename(output.File.Name(), output.name)
+}
+
+// Drops an incomplete output, removing its temporary file. Nothing is left to do once committed.
+func (output *outputFile) discard() {
+	output.Close()
+	if output.temporary {
+		os.Remove(output.File.Name())
+	}
+}
+
+// Checks that every result of a report has a path and a number as risk, between minRisk and maxRisk unless they
+// aren't clamped ('--no-clamp'). The results already streamed can't be checked.
+func validateReport(report DirResult) error {
+	for _, result := range report.allResults() {
+		if result.Path == "" {
+			return fmt.Errorf("result without a path (risk %v)", result.Risk)
+		}
+		if math.IsNaN(result.Risk) || math.IsInf(result.Risk, 0) {
+			return fmt.Errorf("%v: risk %v isn't a number", result.Path, result.Risk)
+		}
+		if clampRisks && (result.Risk < minRisk || result.Risk > maxRisk) {
+			return fmt.Errorf("%v: risk %v out of %v - %v", result.Path, result.Risk, minRisk, maxRisk)
+		}
+	}
+	return nil
+}
+
+// Write the DirResultStructure to the output file
+func writeJsonToFile(outFile io.Writer, data any, indent string) {
+	encoder := json.NewEncoder(outFile)
+	encoder.SetIndent("", indent)
+	// fmt.Printf("Object before writing: %v\n", data)
+	encoder.Encode(data)
+}
+
+/*
+===============
+ Part four: Main
+===============
+*/
+
+// Serves the scan over HTTP until it fails, see serveMux for the requests. SIGHUP reloads the config files for the next
+// requests.
+func serve(address string, scanArgs []string, stderr io.Writer, logger *slog.Logger) error {
+	// A new config is read while the requests go on, and swapped in at once when it is valid
+	reloads := make(chan os.Signal, 1)
+	signal.Notify(reloads, syscall.SIGHUP)
+	defer signal.Stop(reloads)
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		for {
+			select {
+			case <-stopped:
+				return
+			case <-reloads:
+				if errReload := reloadConfig(scanArgs); errReload != nil {
+					logger.Error("error while reloading the config, keeping the current one", "error", errReload)
+					continue
+				}
+				logger.Info("config reloaded")
+			}
+		}
+	}()
+
+	// No write timeout, a scan can take long, but a client can't hold a connection by sending its request slowly
+	server := &http.Server{
+		Addr:              address,
+		Handler:           serveMux(scanArgs, stderr),
+		ReadHeaderTimeout: serveHeaderTimeout,
+		ReadTimeout:       serveReadTimeout,
+	}
+	logger.Info("serving", "address", address)
+	return server.ListenAndServe()
+}
+
+// Routes the requests of the server: GET /scan runs the scan of the command line and answers the JSON report,
+// POST /assess?name=<file name> scores the uploaded body with AssessBytes, GET /metrics gives the Prometheus metrics of
+// the scans. The rules are globals, so requests run one at a time.
+func serveMux(scanArgs []string, stderr io.Writer) *http.ServeMux {
+	var lock sync.Mutex
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/scan", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodGet {
+			http.Error(writer, "expected GET", http.StatusMethodNotAllowed)
+			return
+		}
+		lock.Lock()
+		defer lock.Unlock()
+
+		var report bytes.Buffer
+		exitCode, errRun := Run(scanArgs, &report, stderr)
+		if exitCode == exitError || exitCode == exitUsage {
+			metrics.addError()
+			http.Error(writer, errRun.Error(), http.StatusInternalServerError)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		writer.Write(report.Bytes())
+	})
+
+	mux.HandleFunc("/assess", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			http.Error(writer, "expected POST", http.StatusMethodNotAllowed)
+			return
+		}
+		name := request.URL.Query().Get("name")
+		if name == "" {
+			http.Error(writer, "missing the file name, expected ?name=<file name>", http.StatusBadRequest)
+			return
+		}
+		data, errRead := io.ReadAll(http.MaxBytesReader(writer, request.Body, maxUploadSize))
+		var errTooLarge *http.MaxBytesError
+		if errors.As(errRead, &errTooLarge) {
+			http.Error(writer, fmt.Sprintf("file larger than %v bytes", errTooLarge.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		if errRead != nil {
+			http.Error(writer, errRead.Error(), http.StatusBadRequest)
+			return
+		}
+		lock.Lock()
+		defer lock.Unlock()
+
+		config := servedConfig.Load()
+		weights, zones, extensionConfig = config.weights, config.zones, config.extensionConfig
+		if ignoreCase {
+			lowerExtensionConfig(&extensionConfig)
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(AssessBytes(name, data, time.Now()))
+	})
+
+	mux.HandleFunc("/metrics", func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		metrics.write(writer)
+	})
+
+	return mux
+}
+
+// Runs the whole program with the given arguments (without the program name). The report goes to the '--out' file,
+// or to stdout when it is "-", the summary and the warnings go to stderr. Returns the exit code for the process.
+func Run(args []string, stdout io.Writer, stderr io.Writer) (int, error) {
+
+	// The rules are configured through globals, start each run from the defaults
+	weights = defaultWeights()
+	bands = defaultBands()
+	timeFormat = "rfc3339"
+	roundDigits = -1
+	pathEncoding = "replace"
+	ignoreCase = false
+	clampRisks = true
+	maxReduction = -1
+	zones = nil
+	extensionConfig = initExtensionConfig()
+	sensitiveFileNames = initSensitiveFileNames()
+	gitStatus = newGitStatusCache()
+
+	argValues := readCommandLineArgs(args)
+
+	// A preset comes before the command line, so the arguments given override it
+	if presetName, ok := argValues[presetArg]; ok {
+		preset, known := presets()[presetName]
+		if !known {
+			return exitUsage, fmt.Errorf("unknown preset %q, expected secrets, large-files or recent-activity", presetName)
+		}
+		args = append(append([]string{}, preset.Args...), args...)
+		argValues = readCommandLineArgs(args)
+		weights = preset.Weights
+	}
+
+	// A served scan writes its report in the response, whatever the command line says
+	serveAddress, serveMode := argValues[serveArg]
+	if serveMode {
+		args = append(withoutArg(args, serveArg), outArg, "-")
+		argValues = readCommandLineArgs(args)
+	}
+
+	// The diagnostics go to stderr through a logger, the report and the summary are written as they are
+	quiet := argValues[quietArg] == "true"
+	logOptions := &slog.HandlerOptions{}
+	if quiet {
+		logOptions.Level = slog.LevelWarn
+	}
+	var logger *slog.Logger
+	switch logFormat := argValues[logFormatArg]; logFormat {
+	case "", "text":
+		logger = slog.New(slog.NewTextHandler(stderr, logOptions))
+	case "json":
+		logger = slog.New(slog.NewJSONHandler(stderr, logOptions))
+	default:
+		return exitUsage, fmt.Errorf("unknown log format %q, expected text or json", logFormat)
+	}
+	start := time.Now()
+	cpuStart, _ := processCPUTime()
+
+	rootDir, dirExists := argValues[dirArg]
+	outFileName, outExists := argValues[outArg]
+	rescoreFile, rescoreExists := argValues[rescoreArg]
+	rootsFile, rootsFileExists := argValues[rootsFileArg]
+
+	explainPath, explainMode := argValues[explainFileArg]
+
+	printConfigMode := argValues[printConfigArg] == "true"
+
+	if !explainMode && !printConfigMode && ((!dirExists && !rescoreExists && !rootsFileExists) || !outExists) {
+		return exitUsage, fmt.Errorf("both '%v' (or '%v', or '%v') and '%v' need to be set", dirArg, rootsFileArg, rescoreArg, outArg)
+	}
+
+	// All the directories to scan, merged in one report
+	var roots []string
+	if dirExists {
+		roots = append(roots, rootDir)
+	}
+	if rootsFileExists {
+		fileRoots, errRoots := readRootsFile(rootsFile)
+		if errRoots != nil {
+			return exitUsage, fmt.Errorf("reading the roots file: %w", errRoots)
+		}
+		roots = append(roots, fileRoots...)
+	}
+
+	// A served scan uses the config files as read when serving started, or on the last SIGHUP
+	config := servedConfig.Load()
+	if config == nil {
+		var errConfig error
+		if config, errConfig = loadConfigFiles(args, argValues, weights); errConfig != nil {
+			return exitUsage, errConfig
+		}
+	}
+	weights, zones, extensionConfig = config.weights, config.zones, config.extensionConfig
+
+	// Names differing only by their case are the same file on macOS and Windows, the patterns and extensions must agree
+	ignoreCase = argValues[ignoreCaseArg] == "true"
+	if !ignoreCase && len(roots) > 0 && caseInsensitiveDir(roots[0]) {
+		logger.Info("case-insensitive file system, ignoring the case", "dir", roots[0])
+		ignoreCase = true
+	}
+	if ignoreCase {
+		lowerExtensionConfig(&extensionConfig)
+	}
+
+	if sensitiveNames, ok := argValues[sensitiveNamesArg]; ok {
+		sensitiveFileNames = makeNameSet(strings.Split(sensitiveNames, ","))
+	}
+
+	// The histogram has 10 bins unless told otherwise
+	histogramBins := 0
+	histogramFile, histogramExists := argValues[histogramArg]
+	if histogramExists {
+		histogramBins = 10
+		if binsValue, ok := argValues[histogramBinsArg]; ok {
+			var errBins error
+			histogramBins, errBins = strconv.Atoi(binsValue)
+			if errBins != nil || histogramBins < 1 {
+				return exitUsage, fmt.Errorf("invalid number of bins %q", binsValue)
+			}
+		}
+	}
+
+	if bandsValue, ok := argValues[bandsArg]; ok {
+		parsedBands, errBands := parseBands(bandsValue)
+		if errBands != nil {
+			return exitUsage, errBands
+		}
+		bands = parsedBands
+	}
+
+	// CI fails when at least failCount files are riskier than failOver
+	summary := newSummary(histogramBins, time.Now())
+	failCount := 1
+	if failOverValue, ok := argValues[failOverArg]; ok {
+		var errFailOver error
+		summary.failOver, errFailOver = strconv.ParseFloat(failOverValue, 64)
+		if errFailOver != nil || summary.failOver < 0 {
+			return exitUsage, fmt.Errorf("invalid risk %q for '%v'", failOverValue, failOverArg)
+		}
+	}
+	if failCountValue, ok := argValues[failCountArg]; ok {
+		var errFailCount error
+		failCount, errFailCount = strconv.Atoi(failCountValue)
+		if errFailCount != nil || failCount < 1 {
+			return exitUsage, fmt.Errorf("invalid number of files %q for '%v'", failCountValue, failCountArg)
+		}
+		if summary.failOver < 0 {
+			return exitUsage, fmt.Errorf("'%v' needs '%v'", failCountArg, failOverArg)
+		}
+	}
+
+	// Set before any report is read back, the reports written with the same format can be read
+	if format, ok := argValues[timeFormatArg]; ok {
+		if format == "" {
+			return exitUsage, fmt.Errorf("empty time format for '%v'", timeFormatArg)
+		}
+		timeFormat = format
+	}
+
+	// The baseline is read before the output file is truncated, it may be the same file
+	var regressionBaseline *baseline
+	if baselineFile, ok := argValues[regressionsArg]; ok {
+		minIncrease := 0.0
+		if increaseValue, ok := argValues[minIncreaseArg]; ok {
+			var errIncrease error
+			minIncrease, errIncrease = strconv.ParseFloat(increaseValue, 64)
+			if errIncrease != nil || minIncrease < 0 {
+				return exitUsage, fmt.Errorf("invalid risk %q for '%v'", increaseValue, minIncreaseArg)
+			}
+		}
+		var errBaseline error
+		regressionBaseline, errBaseline = readBaseline(baselineFile, minIncrease)
+		if errBaseline != nil {
+			return exitError, fmt.Errorf("reading the baseline: %w", errBaseline)
+		}
+	} else if _, ok := argValues[minIncreaseArg]; ok {
+		return exitUsage, fmt.Errorf("'%v' needs '%v'", minIncreaseArg, regressionsArg)
+	}
+
+	var policy *Policy
+	if policyFile, ok := argValues[policyArg]; ok {
+		var errPolicy error
+		policy, errPolicy = readPolicy(policyFile)
+		if errPolicy != nil {
+			return exitUsage, fmt.Errorf("reading the policy: %w", errPolicy)
+		}
+	}
+
+	// The bounds of the risks are set before any file is scored, the rescored ones included
+	clampRisks = argValues[noClampArg] != "true"
+
+	if reductionValue, ok := argValues[maxReductionArg]; ok {
+		var errReduction error
+		maxReduction, errReduction = strconv.ParseFloat(reductionValue, 64)
+		if errReduction != nil || maxReduction < 0 {
+			return exitUsage, fmt.Errorf("invalid risk %q for '%v'", reductionValue, maxReductionArg)
+		}
+	}
+
+	// Scoring a previous report doesn't need the disk, so it is done before the output file is truncated
+	var finalResult DirResult
+	if rescoreExists {
+		var errRescore error
+		finalResult, errRescore = rescoreReport(rescoreFile, summary)
+		if errRescore != nil {
+			return exitError, fmt.Errorf("scoring the previous report: %w", errRescore)
+		}
+		if policy != nil {
+			for _, result := range finalResult.Results {
+				finalResult.Violations = append(finalResult.Violations, policy.check(result)...)
+			}
+		}
+		if regressionBaseline != nil {
+			var regressions []FileResult
+			for _, result := range finalResult.Results {
+				if regressionBaseline.regressed(&result) {
+					regressions = append(regressions, result)
+				}
+			}
+			finalResult.Results = regressions
+		}
+	}
+
+	colorize, errColor := useColor(argValues[colorArg], stderr)
+	if errColor != nil {
+		return exitUsage, errColor
+	}
+
+	indent, errIndent := parseIndent(argValues[indentArg])
+	if errIndent != nil {
+		return exitUsage, errIndent
+	}
+
+	if roundValue, ok := argValues[roundArg]; ok {
+		var errRound error
+		roundDigits, errRound = strconv.Atoi(roundValue)
+		if errRound != nil || roundDigits < 0 {
+			return exitUsage, fmt.Errorf("invalid number of decimals %q for '%v'", roundValue, roundArg)
+		}
+	}
+
+	if encodingValue, ok := argValues[outputEncodingArg]; ok {
+		if encodingValue != "replace" && encodingValue != "escape" && encodingValue != "base64" {
+			return exitUsage, fmt.Errorf("unknown output encoding %q, expected replace, escape or base64", encodingValue)
+		}
+		pathEncoding = encodingValue
+	}
+
+	emptyExitCode := 0
+	if codeValue, ok := argValues[emptyExitCodeArg]; ok {
+		var errCode error
+		emptyExitCode, errCode = strconv.Atoi(codeValue)
+		if errCode != nil {
+			return exitUsage, fmt.Errorf("invalid exit code %q", codeValue)
+		}
+	}
+
+	options, errOptions := readOptions(argValues)
+	if errOptions != nil {
+		return exitUsage, errOptions
+	}
+
+	effective := effectiveConfig{
+		Roots:          roots,
+		Options:        options,
+		Weights:        weights,
+		Zones:          zones,
+		Bands:          bands,
+		Extensions:     extensionConfig,
+		SensitiveNames: slices.Sorted(maps.Keys(sensitiveFileNames)),
+		TimeFormat:     timeFormat,
+		ClampRisks:     clampRisks,
+		MaxReduction:   maxReduction,
+		RoundDigits:    roundDigits,
+		IgnoreCase:     ignoreCase,
+		ConfigFiles:    make(map[string][]string),
+	}
+	if printConfigMode {
+		for _, configArg := range []string{weightsArg, extConfigArg, policyArg, rootsFileArg, ignoreFileArg} {
+			for _, location := range readRepeatedArg(args, configArg) {
+				effective.ConfigFiles[configArg] = append(effective.ConfigFiles[configArg], redactLocation(location))
+			}
+		}
+		encoder := json.NewEncoder(stdout)
+		encoder.SetIndent("", "    ")
+		if errEncode := encoder.Encode(effective); errEncode != nil {
+			return exitError, fmt.Errorf("writing the configuration: %w", errEncode)
+		}
+		return exitOk, nil
+	}
+
+	if explainMode {
+		context := fileContext{maxDirEntries: options.MaxFilesPerDir}
+		if previousFile, ok := argValues[previousManifestArg]; ok {
+			var errPrevious error
+			if context.previous, errPrevious = readManifest(previousFile); errPrevious != nil {
+				return exitError, fmt.Errorf("reading the previous manifest: %w", errPrevious)
+			}
+		}
+		if errExplain := explainFile(stdout, explainPath, context); errExplain != nil {
+			return exitError, fmt.Errorf("explaining the risk: %w", errExplain)
+		}
+		return exitOk, nil
+	}
+
+	// The command line is valid, each request runs it again
+	if serveMode {
+		servedConfig.Store(config)
+		defer servedConfig.Store(nil)
+		if errServe := serve(serveAddress, args, stderr, logger); errServe != nil {
+			return exitError, fmt.Errorf("serving: %w", errServe)
+		}
+		return exitOk, nil
+	}
+
+	// In append mode, read the previous report before the output file is truncated
+	var previousResult DirResult
+	appendMode := argValues[appendArg] == "true"
+	toStdout := outFileName == "-"
+	if appendMode && toStdout {
+		return exitUsage, fmt.Errorf("'%v' needs an output file, not stdout", appendArg)
+	}
+
+	// Check the output before scanning, so we don't waste a whole scan on a path we can't write to
+	if !toStdout {
+		if errOutDir := checkOutputDir(outFileName, argValues[mkdirOutArg] == "true"); errOutDir != nil {
+			return exitError, fmt.Errorf("preparing the output file: %w", errOutDir)
+		}
+	}
+
+	if appendMode {
+		var errReport error
+		previousResult, errReport = readReport(outFileName)
+		if errReport != nil {
+			return exitError, fmt.Errorf("reading the previous report: %w", errReport)
+		}
+	}
+
+	// A stream is one record per line, which can't be merged or indented
+	streamMode := argValues[streamArg] == "true"
+	if streamMode {
+		indent = ""
+	}
+	arrayMode := argValues[streamArrayArg] == "true"
+
+	dedupeBy, dedupeExists := argValues[dedupeByArg]
+	if dedupeExists && dedupeBy != "path" && dedupeBy != "basename" && dedupeBy != "risk" {
+		return exitUsage, fmt.Errorf("unknown dedupe %q, expected path, basename or risk", dedupeBy)
+	}
+
+	// The streamed results are written once and for all, so they can't be merged, limited or deduplicated afterwards
+	for _, streamingArg := range []string{streamArg, streamArrayArg, outputDirArg} {
+		if _, ok := argValues[streamingArg]; !ok {
+			continue
+		}
+		for _, conflictingArg := range []string{appendArg, topGlobalArg, dedupeByArg} {
+			if _, ok := argValues[conflictingArg]; ok {
+				return exitUsage, fmt.Errorf("'%v' and '%v' can't be used together", streamingArg, conflictingArg)
+			}
+		}
+	}
+	// A template is checked before the scan, a broken one would only fail at the end
+	var outputTemplate *template.Template
+	if templateValue, ok := argValues[outputTemplateArg]; ok {
+		var errTemplate error
+		outputTemplate, errTemplate = template.New("output").Parse(templateValue)
+		if errTemplate == nil {
+			// Parsing doesn't know the fields of a result, a run on an empty one finds the unknown ones
+			errTemplate = outputTemplate.Execute(io.Discard, FileResult{})
+		}
+		if errTemplate != nil {
+			return exitUsage, fmt.Errorf("reading '%v': %w", outputTemplateArg, errTemplate)
+		}
+		if streamMode || arrayMode {
+			return exitUsage, fmt.Errorf("'%v' can't be used with '%v' or '%v'", outputTemplateArg, streamArg, streamArrayArg)
+		}
+	}
+
+	// '--top1' is '--top-global 1' with a minimal output, for shell one-liners
+	if argValues[top1Arg] == "true" {
+		// Its output isn't a report, which '--append' would have to read back
+		if _, ok := argValues[topGlobalArg]; ok || outputTemplate != nil || streamMode || arrayMode || argValues[appendArg] == "true" {
+			return exitUsage, fmt.Errorf("'%v' can't be used with '%v', '%v', '%v', '%v' or '%v'", top1Arg, topGlobalArg, outputTemplateArg, streamArg, streamArrayArg, appendArg)
+		}
+		options.TopGlobal = 1
+		outputTemplate = template.Must(template.New("top1").Parse("{{.Path}}\t{{.Risk}}"))
+	}
+
+	// Every scored file is kept, riskiest first, for a full inventory
+	reportAll := argValues[reportAllArg] == "true"
+	if reportAll {
+		_, perDirSet := argValues[topPerDirArg]
+		if _, globalSet := argValues[topGlobalArg]; perDirSet || globalSet || argValues[top1Arg] == "true" {
+			return exitUsage, fmt.Errorf("'%v' can't be used with '%v', '%v' or '%v'", reportAllArg, topPerDirArg, topGlobalArg, top1Arg)
+		}
+		options.TopPerDir = math.MaxInt
+		logger.Warn("every scored file is reported, the report can be huge")
+	}
+
+	// The roots are merged in one result set unless told otherwise, '--partition-roots' being '--merge-roots-output false'
+	partitionMode := argValues[partitionRootsArg] == "true"
+	if mergeValue, ok := argValues[mergeRootsArg]; ok {
+		merge, errMerge := strconv.ParseBool(mergeValue)
+		if errMerge != nil {
+			return exitUsage, fmt.Errorf("invalid value %q for '%v', expected true or false", mergeValue, mergeRootsArg)
+		}
+		if merge && partitionMode {
+			return exitUsage, fmt.Errorf("'%v true' and '%v' can't be used together", mergeRootsArg, partitionRootsArg)
+		}
+		partitionMode = !merge
+	}
+	if partitionMode && (streamMode || arrayMode) {
+		return exitUsage, fmt.Errorf("'%v' can't be used with '%v' or '%v'", partitionRootsArg, streamArg, streamArrayArg)
+	}
+	outputDir, outputDirMode := argValues[outputDirArg]
+	if outputDirMode && (streamMode || arrayMode || partitionMode || outputTemplate != nil) {
+		return exitUsage, fmt.Errorf("'%v' can't be used with '%v', '%v', '%v', '%v' or '%v'", outputDirArg, streamArg, streamArrayArg, partitionRootsArg, outputTemplateArg, top1Arg)
+	}
+	if arrayMode && (streamMode || argValues[statsOnlyArg] == "true") {
+		return exitUsage, fmt.Errorf("'%v' can't be used with '%v' or '%v'", streamArrayArg, streamArg, statsOnlyArg)
+	}
+	// The flat output is only the results, for the consumers that don't need the report around them
+	flatMode := argValues[flatArg] == "true"
+	if flatMode {
+		for _, conflictingArg := range []string{streamArg, streamArrayArg, outputDirArg, outputTemplateArg, top1Arg, appendArg, statsOnlyArg} {
+			if _, ok := argValues[conflictingArg]; ok {
+				return exitUsage, fmt.Errorf("'%v' and '%v' can't be used together", flatArg, conflictingArg)
+			}
+		}
+	}
+
+	// A failed scan or an invalid report leaves the previous report in place, unless it is streamed
+	out := stdout
+	var outFile *outputFile
+	if !toStdout {
+		var fileOpenErr error
+		outFile, fileOpenErr = createOutput(outFileName, streamMode || arrayMode)
+		if nil != fileOpenErr {
+			return exitError, fmt.Errorf("opening the output file: %w", fileOpenErr)
+		}
+		defer outFile.discard()
+		out = outFile
+	}
+
+	if cpuProfileFile, ok := argValues[cpuProfileArg]; ok {
+		stopCPUProfile, errProfile := startCPUProfile(cpuProfileFile)
+		if errProfile != nil {
+			return exitError, fmt.Errorf("starting the CPU profile: %w", errProfile)
+		}
+		defer stopCPUProfile()
+	}
+
+	var array *arrayWriter
+	if arrayMode {
+		array = &arrayWriter{writer: out}
+	}
+
+	var cacheOut *outputFile
+	if !rescoreExists {
+		state := scanState{options: options, summary: summary, logger: logger, followed: make(map[string]bool), policy: policy, baseline: regressionBaseline}
+		if options.SamplePercent > 0 {
+			state.sample = rand.New(rand.NewSource(options.Seed))
+			summary.SamplePercent = options.SamplePercent
+			logger.Info("sampling the files", "percent", options.SamplePercent, "seed", options.Seed)
+		}
+		if streamMode && argValues[statsOnlyArg] != "true" {
+			state.stream = json.NewEncoder(out)
+		}
+		state.array = array
+		if outputDirMode {
+			if errMkdir := os.MkdirAll(outputDir, 0755); errMkdir != nil {
+				return exitError, fmt.Errorf("creating the output directory: %w", errMkdir)
+			}
+			state.outputDir = outputDir
+		}
+		if manifestFile, ok := argValues[manifestArg]; ok {
+			manifest, errManifest := os.Create(manifestFile)
+			if errManifest != nil {
+				return exitError, fmt.Errorf("opening the manifest: %w", errManifest)
+			}
+			defer manifest.Close()
+			state.manifest = json.NewEncoder(manifest)
+		}
+		if previousFile, ok := argValues[previousManifestArg]; ok {
+			var errPrevious error
+			state.previous, errPrevious = readManifest(previousFile)
+			if errPrevious != nil {
+				return exitError, fmt.Errorf("reading the previous manifest: %w", errPrevious)
+			}
+		}
+		// The cache is read before it is written again with the results of this scan
+		if cacheFile, ok := argValues[cacheArg]; ok {
+			var errCache error
+			state.cache, errCache = readCache(cacheFile, effective.fingerprint())
+			if errCache != nil {
+				return exitError, fmt.Errorf("reading the cache: %w", errCache)
+			}
+			// Like the report, the cache is only replaced by a complete scan
+			var errCreate error
+			cacheOut, errCreate = createOutput(cacheFile, false)
+			if errCreate != nil {
+				return exitError, fmt.Errorf("opening the cache: %w", errCreate)
+			}
+			defer cacheOut.discard()
+			state.cacheWriter = json.NewEncoder(cacheOut)
+			if errHeader := state.cacheWriter.Encode(cacheHeader{Version: cacheVersion, Fingerprint: effective.fingerprint()}); errHeader != nil {
+				return exitError, fmt.Errorf("writing the cache: %w", errHeader)
+			}
+		}
+		// Without '--top-global', the report keeps 10 files, or as many as a directory does: its memory doesn't grow with the tree
+		globalLimit := options.TopGlobal
+		if globalLimit == 0 {
+			globalLimit = max(maxResults, options.TopPerDir)
+		}
+		state.global = NewResultCollector(globalLimit)
+
+		walkStart := time.Now()
+		for _, root := range roots {
+			// A root reached through symlinks is walked and reported under its real path, not under the name of the link
+			absoluteDir, _ := realPath(windowsPath(root))
+			if len(roots) == 1 {
+				finalResult.Dir = absoluteDir
+			} else {
+				finalResult.Roots = append(finalResult.Roots, absoluteDir)
+			}
+
+			// The global ignore file applies to the whole tree
+			var globalIgnores []ignorePattern
+			if ignoreFile, ok := argValues[ignoreFileArg]; ok {
+				var errIgnore error
+				globalIgnores, errIgnore = readIgnoreFile(ignoreFile, absoluteDir)
+				if errIgnore != nil {
+					return exitError, fmt.Errorf("reading the ignore file: %w", errIgnore)
+				}
+			}
+			if excludes, ok := argValues[excludeArg]; ok {
+				excludePatterns, errExclude := parseIgnorePatterns(strings.Split(excludes, ","), absoluteDir)
+				if errExclude != nil {
+					return exitUsage, fmt.Errorf("reading '%v': %w", excludeArg, errExclude)
+				}
+				globalIgnores = append(globalIgnores, excludePatterns...)
+			}
+
+			dirResults := assessDirRisk(absoluteDir, globalIgnores, &state)
+			if state.err != nil {
+				return exitError, state.err
+			}
+			for _, res := range dirResults {
+				finalResult.Results = append(finalResult.Results, res)
+			}
+		}
+
+		if state.global != nil {
+			finalResult.Results = state.global.Results()
+		}
+		// The results are in the output directory, the report keeps the summary and the warnings
+		if outputDirMode {
+			finalResult.Results = []FileResult{}
+		}
+		relativePaths(finalResult.Results, options.RelativeTo)
+
+		state.summary.finish()
+		state.summary.Timing = &Timing{
+			Walk:    (time.Since(walkStart) - state.scoring - state.output).Seconds(),
+			Scoring: state.scoring.Seconds(),
+			Output:  state.output.Seconds(),
+		}
+		finalResult.Summary = state.summary
+		finalResult.Warnings = state.warnings
+		finalResult.Violations = state.violations
+	}
+
+	if appendMode {
+		finalResult.Results = mergeResults(previousResult.allResults(), finalResult.Results)
+	}
+
+	if dedupeExists {
+		finalResult.Results = dedupeResults(finalResult.Results, dedupeBy)
+	}
+
+	if reportAll {
+		sort.SliceStable(finalResult.Results, func(i, j int) bool {
+			return lessRisky(finalResult.Results[j], finalResult.Results[i])
+		})
+	}
+
+	// The walk only kept its riskiest file, the rescored and appended results are limited here
+	if argValues[top1Arg] == "true" && len(finalResult.Results) > 1 {
+		finalResult.Results = []FileResult{slices.MaxFunc(finalResult.Results, func(a, b FileResult) int {
+			if lessRisky(a, b) {
+				return -1
+			}
+			if lessRisky(b, a) {
+				return 1
+			}
+			return 0
+		})}
+	}
+
+	if memProfileFile, ok := argValues[memProfileArg]; ok {
+		if errProfile := writeMemProfile(memProfileFile); errProfile != nil {
+			logger.Error("error while writing the memory profile", "file", memProfileFile, "error", errProfile)
+		}
+	}
+
+	if partitionMode {
+		partitionByRoot(&finalResult, options.RelativeTo)
+	}
+
+	// Only the aggregated metrics are kept, the list is empty rather than missing
+	if argValues[statsOnlyArg] == "true" {
+		finalResult.Results = []FileResult{}
+		finalResult.RootResults = nil
+	}
+
+	if finalResult.Summary != nil && finalResult.Summary.Timing != nil {
+		finalResult.Summary.Timing.Total = time.Since(start).Seconds()
+		if cpu, ok := processCPUTime(); ok {
+			finalResult.Summary.Timing.CPU = (cpu - cpuStart).Seconds()
+		}
+	}
+
+	// A rule gone wrong must not produce a report that looks fine
+	if errValid := validateReport(finalResult); errValid != nil {
+		return exitError, fmt.Errorf("invalid report, not written: %w", errValid)
+	}
+
+	if arrayMode {
+		// The scanned results are already written, only the rescored ones are left
+		for _, res := range finalResult.Results {
+			if errWrite := array.write(res); errWrite != nil {
+				return exitError, fmt.Errorf("writing the report: %w", errWrite)
+			}
+		}
+		if errClose := array.close(); errClose != nil {
+			return exitError, fmt.Errorf("writing the report: %w", errClose)
+		}
+	} else if outputTemplate != nil {
+		if errTemplate := writeTemplate(out, outputTemplate, finalResult.allResults()); errTemplate != nil {
+			return exitError, fmt.Errorf("writing the report: %w", errTemplate)
+		}
+	} else if flatMode {
+		writeJsonToFile(out, finalResult.allResults(), indent)
+	} else {
+		writeJsonToFile(out, finalResult, indent)
+	}
+
+	if outFile != nil {
+		if errReplace := outFile.commit(); errReplace != nil {
+			return exitError, fmt.Errorf("writing the report: %w", errReplace)
+		}
+	}
+	if cacheOut != nil {
+		if errReplace := cacheOut.commit(); errReplace != nil {
+			return exitError, fmt.Errorf("writing the cache: %w", errReplace)
+		}
+	}
+
+	if argValues[summaryArg] == "true" {
+		writeSummary(stderr, finalResult, colorize)
+	} else if stderrFile, isFile := stderr.(*os.File); isFile && isTerminal(stderrFile) && !toStdout && !quiet {
+		// Someone is watching: a glance at the results saves opening the report
+		writeEndSummary(stderr, finalResult, outFileName, colorize)
+	}
+
+	if histogramExists {
+		if errHistogram := writeHistogram(histogramFile, finalResult.Summary); errHistogram != nil {
+			logger.Error("error while writing the histogram", "file", histogramFile, "error", errHistogram)
+		}
+	}
+
+	metrics.addScan(finalResult, time.Since(start))
+
+	// A single line of key=value pairs, for shell pipelines that don't parse json
+	if argValues[compactSummaryArg] == "true" {
+		fmt.Fprintf(stderr, "scanned=%v scored=%v high=%v max=%v duration=%.3fs\n", finalResult.Summary.seenFiles,
+			finalResult.Summary.ScoredFiles, finalResult.Summary.Bands[bands[len(bands)-1].Label], finalResult.Summary.MaxRisk,
+			time.Since(start).Seconds())
+	}
+
+	// An empty report usually means the filters are too aggressive or the wrong directory was given
+	logger.Info("scan done", "scoredFiles", finalResult.Summary.ScoredFiles, "reportedFiles", len(finalResult.allResults()),
+		"warnings", len(finalResult.Warnings), "duration", time.Since(start))
+	if finalResult.Summary.ScoredFiles == 0 {
+		logger.Warn("no file was scored, check the directory and the filters")
+		return emptyExitCode, nil
+	}
+	if len(finalResult.Violations) > 0 {
+		for _, violation := range finalResult.Violations {
+			logger.Error("policy violation", "rule", violation.Rule, "path", violation.Path, "risk", violation.Risk)
+		}
+		return exitPolicy, nil
+	}
+	if summary.failOver >= 0 && summary.riskyFiles >= failCount {
+		logger.Error("too many risky files", "riskyFiles", summary.riskyFiles, "failOver", summary.failOver, "failCount", failCount)
+		return exitRisky, nil
+	}
+	return exitOk, nil
+}
+
+// Reads the options of the walk from the arguments
+func readOptions(argValues map[string]string) (Options, error) {
+	options := DefaultOptions()
+	options.SkipHiddenDirs = argValues[skipHiddenDirsArg] == "true"
+	options.SkipVcs = argValues[skipVcsArg] == "true"
+	options.NoSizeFloor = argValues[noSizeFloorArg] == "true"
+	options.OnlyMismatchedType = argValues[onlyMismatchedArg] == "true"
+	options.ReportEmptyDirs = argValues[reportEmptyDirsArg] == "true"
+	options.ArchivePropagate = argValues[archivePropagateArg] == "true"
+	options.IgnoreOwn = argValues[ignoreOwnArg] == "true"
+	options.IncludeSpecialFiles = argValues[specialFilesArg] == "true"
+	options.FollowDirSymlinks = argValues[followDirSymlinksArg] == "true"
+	options.IgnoreEmpty = argValues[ignoreEmptyArg] == "true"
+	if onlyExt, ok := argValues[onlyExtArg]; ok {
+		for _, extension := range strings.Split(onlyExt, ",") {
+			options.OnlyExtensions = append(options.OnlyExtensions, strings.ToLower(normalizeExtension(extension)))
+		}
+	}
+	if onError, ok := argValues[onErrorArg]; ok {
+		if onError != "skip" && onError != "warn" && onError != "fail" {
+			return options, fmt.Errorf("unknown error policy %q, expected skip, warn or fail", onError)
+		}
+		options.OnError = onError
+	}
+	if relativeTo, ok := argValues[relativeToArg]; ok {
+		var errAbs error
+		options.RelativeTo, errAbs = realPath(relativeTo)
+		if errAbs != nil {
+			return options, fmt.Errorf("invalid directory %q for '%v': %w", relativeTo, relativeToArg, errAbs)
+		}
+	}
+	options.Hash = argValues[hashArg]
+	if options.GitBlame = argValues[gitBlameArg] == "true"; options.GitBlame {
+		if _, errGit := exec.LookPath("git"); errGit != nil {
+			return options, fmt.Errorf("'%v' needs git: %w", gitBlameArg, errGit)
+		}
+	}
+	if options.Hash != "" && options.Hash != "md5" && options.Hash != "sha256" {
+		return options, fmt.Errorf("unknown hash algorithm %q, expected md5 or sha256", options.Hash)
+	}
+	if maxFilesValue, ok := argValues[maxFilesPerDirArg]; ok {
+		var errMaxFiles error
+		options.MaxFilesPerDir, errMaxFiles = strconv.Atoi(maxFilesValue)
+		if errMaxFiles != nil || options.MaxFilesPerDir < 0 {
+			return options, fmt.Errorf("invalid number of files %q for '%v'", maxFilesValue, maxFilesPerDirArg)
+		}
+	}
+	if sampleValue, ok := argValues[sampleArg]; ok {
+		var errSample error
+		options.SamplePercent, errSample = strconv.ParseFloat(strings.TrimSuffix(sampleValue, "%"), 64)
+		if errSample != nil || options.SamplePercent <= 0 || options.SamplePercent > 100 {
+			return options, fmt.Errorf("invalid percentage %q for '%v', expected more than 0 and up to 100", sampleValue, sampleArg)
+		}
+		// Without a seed, each run draws other files
+		options.Seed = time.Now().UnixNano()
+	}
+	if seedValue, ok := argValues[seedArg]; ok {
+		if options.SamplePercent == 0 {
+			return options, fmt.Errorf("'%v' needs '%v'", seedArg, sampleArg)
+		}
+		var errSeed error
+		options.Seed, errSeed = strconv.ParseInt(seedValue, 10, 64)
+		if errSeed != nil {
+			return options, fmt.Errorf("invalid seed %q for '%v'", seedValue, seedArg)
+		}
+	}
+	if retryValue, ok := argValues[retryArg]; ok {
+		var errRetry error
+		options.Retries, errRetry = strconv.Atoi(retryValue)
+		if errRetry != nil || options.Retries < 0 {
+			return options, fmt.Errorf("invalid number of retries %q for '%v'", retryValue, retryArg)
+		}
+	}
+	for _, topArg := range []string{topPerDirArg, topGlobalArg} {
+		topValue, ok := argValues[topArg]
+		if !ok {
+			continue
+		}
+		top, errTop := strconv.Atoi(topValue)
+		if errTop != nil || top < 1 {
+			return options, fmt.Errorf("invalid number of files %q for '%v'", topValue, topArg)
+		}
+		if topArg == topPerDirArg {
+			options.TopPerDir = top
+		} else {
+			options.TopGlobal = top
+		}
+	}
+	// Every size accepts a unit ("10MB", see parseSize)
+	sizeOptions := []struct {
+		arg   string
+		value *int64
+	}{
+		{ignoreSizeMinArg, &options.IgnoreSizeMin},
+		{ignoreSizeMaxArg, &options.IgnoreSizeMax},
+		{excludeLargerArg, &options.ExcludeLargerThan},
+		{excludeSmallerArg, &options.ExcludeSmallerThan},
+		{hashMaxSizeArg, &options.HashMaxSize},
+		{sizeFloorArg, &options.SizeFloor},
+		{excludeIfContainsBytesArg, &options.ExcludeIfContainsBytes},
+	}
+	for _, sizeOption := range sizeOptions {
+		sizeValue, ok := argValues[sizeOption.arg]
+		if !ok {
+			continue
+		}
+		size, errSize := parseSize(sizeValue)
+		if errSize != nil {
+			return options, fmt.Errorf("%w for '%v'", errSize, sizeOption.arg)
+		}
+		*sizeOption.value = size
+	}
+	if options.ExcludeIfContainsBytes < 1 || options.ExcludeIfContainsBytes > maxContainsBytes {
+		return options, fmt.Errorf("invalid size %v for '%v', expected 1 to %v bytes", options.ExcludeIfContainsBytes, excludeIfContainsBytesArg, maxContainsBytes)
+	}
+	if pattern, ok := argValues[excludeIfContainsArg]; ok {
+		var errPattern error
+		if options.ExcludeIfContains, errPattern = regexp.Compile(pattern); errPattern != nil {
+			return options, fmt.Errorf("invalid pattern %q for '%v': %w", pattern, excludeIfContainsArg, errPattern)
+		}
+	}
+	if sinceValue, ok := argValues[sinceArg]; ok {
+		var errSince error
+		options.Since, errSince = parseSince(sinceValue, time.Now())
+		if errSince != nil {
+			return options, errSince
+		}
+	}
+	return options, nil
+}
+
+func main() {
+	exitCode, err := Run(os.Args[1:], os.Stdout, os.Stderr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+	os.Exit(exitCode)
 }